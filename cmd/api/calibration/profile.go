@@ -0,0 +1,199 @@
+// Package calibration corrects readings of individual sensors
+package calibration
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Kinds of correction a profile applies.
+const (
+	// KindLinear computes gain*value + offset.
+	KindLinear = "linear"
+	// KindPolynomial computes c0 + c1*value + c2*value^2 + ...
+	KindPolynomial = "polynomial"
+	// KindEPAPurpleAir applies the US EPA 2021 correction for PurpleAir
+	// PM2.5 (CF=1) readings, which needs the relative humidity.
+	KindEPAPurpleAir = "epa_purpleair"
+)
+
+// HumidityMeasurement is the measurement name whose value feeds humidity
+// compensated corrections.
+const HumidityMeasurement = "humidity"
+
+var ErrNeedsHumidity = errors.New("calibration needs a humidity reading")
+
+// Profile corrects the readings of one measurement of one sensor taken at
+// or after EffectiveFrom, until the next profile of the pair takes over.
+type Profile struct {
+	ID            int64     `json:"id"`
+	SensorID      string    `json:"sensor_id"`
+	Measurement   string    `json:"measurement"`
+	Kind          string    `json:"kind"`
+	Offset        float64   `json:"offset,omitempty"`
+	Gain          *float64  `json:"gain,omitempty"`
+	Coefficients  []float64 `json:"coefficients,omitempty"`
+	EffectiveFrom time.Time `json:"effective_from"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Validate checks a profile before it is persisted.
+func (p *Profile) Validate() error {
+	if len(p.SensorID) == 0 || len(p.Measurement) == 0 {
+		return fmt.Errorf("sensor_id and measurement are required")
+	}
+	switch p.Kind {
+	case KindLinear:
+		if p.Gain != nil && *p.Gain == 0 {
+			return fmt.Errorf("gain must not be 0")
+		}
+	case KindPolynomial:
+		if len(p.Coefficients) == 0 {
+			return fmt.Errorf("coefficients are required for %s", KindPolynomial)
+		}
+	case KindEPAPurpleAir:
+	default:
+		return fmt.Errorf("kind must be '%s', '%s' or '%s'", KindLinear, KindPolynomial, KindEPAPurpleAir)
+	}
+	return nil
+}
+
+// Apply returns the corrected value. humidity is the relative humidity in
+// percent at the time of the reading, or nil when unknown.
+func (p *Profile) Apply(value float64, humidity *float64) (float64, error) {
+	switch p.Kind {
+	case KindLinear:
+		gain := 1.0
+		if p.Gain != nil {
+			gain = *p.Gain
+		}
+		return gain*value + p.Offset, nil
+
+	case KindPolynomial:
+		// Horner's method, highest degree first.
+		out := 0.0
+		for n := len(p.Coefficients) - 1; n >= 0; n-- {
+			out = out*value + p.Coefficients[n]
+		}
+		return out, nil
+
+	case KindEPAPurpleAir:
+		if humidity == nil {
+			return value, ErrNeedsHumidity
+		}
+		return epaPurpleAir(value, *humidity), nil
+	}
+	return value, fmt.Errorf("unknown calibration kind %q", p.Kind)
+}
+
+// epaPurpleAir is the piecewise 2021 US EPA correction, which blends
+// linear fits between ranges and adds a quadratic term for smoke levels.
+func epaPurpleAir(pa, rh float64) float64 {
+	switch {
+	case pa < 30:
+		return 0.524*pa - 0.0862*rh + 5.75
+	case pa < 50:
+		w := pa/20 - 3.0/2
+		return (0.786*w+0.524*(1-w))*pa - 0.0862*rh + 5.75
+	case pa < 210:
+		return 0.786*pa - 0.0862*rh + 5.75
+	case pa < 260:
+		w := pa/50 - 21.0/5
+		return (0.69*w+0.786*(1-w))*pa - 0.0862*rh*(1-w) + 2.966*w + 5.75*(1-w) + 8.84e-4*pa*pa*w
+	default:
+		return 2.966 + 0.69*pa + 8.84e-4*pa*pa
+	}
+}
+
+type key struct {
+	sensorID    string
+	measurement string
+}
+
+// Profiles holds every calibration profile, ordered by EffectiveFrom per
+// sensor measurement. It is read on every ingested reading and updated
+// through the calibration API.
+type Profiles struct {
+	mu       sync.RWMutex
+	profiles map[key][]Profile
+}
+
+func NewProfiles() *Profiles {
+	return &Profiles{profiles: make(map[key][]Profile)}
+}
+
+func (p *Profiles) Load(items []Profile) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.profiles = make(map[key][]Profile)
+	for _, item := range items {
+		p.add(item)
+	}
+}
+
+func (p *Profiles) Add(item Profile) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.add(item)
+}
+
+func (p *Profiles) add(item Profile) {
+	k := key{sensorID: item.SensorID, measurement: item.Measurement}
+	list := append(p.profiles[k], item)
+	sort.SliceStable(list, func(i, j int) bool { return list[i].EffectiveFrom.Before(list[j].EffectiveFrom) })
+	p.profiles[k] = list
+}
+
+func (p *Profiles) Delete(sensorID, measurement string, id int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	k := key{sensorID: sensorID, measurement: measurement}
+	list := p.profiles[k][:0:0]
+	for _, item := range p.profiles[k] {
+		if item.ID != id {
+			list = append(list, item)
+		}
+	}
+	if len(list) == 0 {
+		delete(p.profiles, k)
+		return
+	}
+	p.profiles[k] = list
+}
+
+// List returns the profiles of a sensor, of one of its measurements when
+// measurement is not empty, ordered by measurement and EffectiveFrom.
+func (p *Profiles) List(sensorID, measurement string) []Profile {
+	p.mu.RLock()
+	out := []Profile{}
+	for k, list := range p.profiles {
+		if k.sensorID == sensorID && (measurement == "" || k.measurement == measurement) {
+			out = append(out, list...)
+		}
+	}
+	p.mu.RUnlock()
+
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Measurement != out[j].Measurement {
+			return out[i].Measurement < out[j].Measurement
+		}
+		return out[i].EffectiveFrom.Before(out[j].EffectiveFrom)
+	})
+	return out
+}
+
+// Active returns the profile in effect for a reading taken at ts, if any.
+func (p *Profiles) Active(sensorID, measurement string, ts time.Time) (Profile, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	list := p.profiles[key{sensorID: sensorID, measurement: measurement}]
+	for n := len(list) - 1; n >= 0; n-- {
+		if !list[n].EffectiveFrom.After(ts) {
+			return list[n], true
+		}
+	}
+	return Profile{}, false
+}
@@ -0,0 +1,71 @@
+// Package codec decodes ingestion payloads according to their Content-Type
+package codec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"sensor/cmd/api/models"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+var ErrUnsupportedMediaType = errors.New("unsupported media type")
+
+const (
+	MediaTypeJSON     = "application/json"
+	MediaTypeCBOR     = "application/cbor"
+	MediaTypeProtobuf = "application/x-protobuf"
+)
+
+// maxBinaryBody bounds bodies that are read whole before decoding.
+const maxBinaryBody = 1 << 20
+
+// DecodeCreateMeasurementReq decodes a create request from JSON, CBOR or
+// Protobuf (see proto/measurement.proto). Requests without a Content-Type,
+// or with the form and text types that simple HTTP clients send by default,
+// are read as JSON. Malformed payloads wrap models.ErrBadPayload; unknown
+// content types return ErrUnsupportedMediaType.
+func DecodeCreateMeasurementReq(contentType string, body io.Reader) (models.CreateMeasurementReq, error) {
+	var req models.CreateMeasurementReq
+
+	mediaType := ""
+	if contentType != "" {
+		mt, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return req, fmt.Errorf("%w: %q", ErrUnsupportedMediaType, contentType)
+		}
+		mediaType = mt
+	}
+
+	switch mediaType {
+	case "", MediaTypeJSON, "text/plain", "application/x-www-form-urlencoded":
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			return req, fmt.Errorf("%w: %w", models.ErrBadPayload, err)
+		}
+
+	case MediaTypeCBOR:
+		// Without cbor tags the json tags of the model are used.
+		if err := cbor.NewDecoder(io.LimitReader(body, maxBinaryBody)).Decode(&req); err != nil {
+			return req, fmt.Errorf("%w: %w", models.ErrBadPayload, err)
+		}
+
+	case MediaTypeProtobuf, "application/protobuf", "application/vnd.google.protobuf":
+		b, err := io.ReadAll(io.LimitReader(body, maxBinaryBody+1))
+		if err != nil {
+			return req, fmt.Errorf("%w: %w", models.ErrBadPayload, err)
+		}
+		if len(b) > maxBinaryBody {
+			return req, fmt.Errorf("%w: body larger than %d bytes", models.ErrBadPayload, maxBinaryBody)
+		}
+		if req, err = unmarshalProto(b); err != nil {
+			return req, fmt.Errorf("%w: %w", models.ErrBadPayload, err)
+		}
+
+	default:
+		return req, fmt.Errorf("%w: %s", ErrUnsupportedMediaType, mediaType)
+	}
+	return req, nil
+}
@@ -0,0 +1,221 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"sensor/cmd/api/models"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+// protoMsg builds protobuf messages field by field.
+type protoMsg []byte
+
+func (m protoMsg) str(num protowire.Number, s string) protoMsg {
+	m = protowire.AppendTag(m, num, protowire.BytesType)
+	return protowire.AppendString(m, s)
+}
+
+func (m protoMsg) double(num protowire.Number, v float64) protoMsg {
+	m = protowire.AppendTag(m, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(m, math.Float64bits(v))
+}
+
+func (m protoMsg) varint(num protowire.Number, v uint64) protoMsg {
+	m = protowire.AppendTag(m, num, protowire.VarintType)
+	return protowire.AppendVarint(m, v)
+}
+
+func (m protoMsg) msg(num protowire.Number, sub protoMsg) protoMsg {
+	m = protowire.AppendTag(m, num, protowire.BytesType)
+	return protowire.AppendBytes(m, sub)
+}
+
+var ts = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// Payloads shared by the JSON and CBOR cases, which decode through the
+// json tags of the model.
+var (
+	singleMap = map[string]any{
+		"sensor_name": "node",
+		"timestamp":   ts.Format(time.RFC3339),
+		"message_id":  "m1",
+		"measurement": "temperature",
+		"parameter":   "BME280",
+		"value":       21.5,
+		"unit":        "°C",
+	}
+	multiMap = map[string]any{
+		"sensor_name": "node",
+		"measurements": []any{
+			map[string]any{"measurement": "temperature", "value": 21.5, "unit": "°C"},
+			map[string]any{"measurement": "humidity", "parameter": "BME280", "value": 48.0},
+		},
+	}
+	unknownMap = map[string]any{
+		"sensor_name": "node",
+		"firmware":    "1.2.0",
+		"rssi":        -67,
+		"measurements": []any{
+			map[string]any{"measurement": "temperature", "value": 21.5, "battery": 3.7, "tags": []any{"a"}},
+		},
+	}
+	internalMap = map[string]any{
+		"sensor_name": "node",
+		"measurements": []any{
+			map[string]any{
+				"measurement":    "temperature",
+				"value":          21.5,
+				"flag":           "outlier",
+				"Flag":           "outlier",
+				"raw_value":      99.0,
+				"RawValue":       99.0,
+				"raw_unit":       "°F",
+				"RawUnit":        "°F",
+				"calibration_id": 7,
+				"CalibrationID":  7,
+				"derived":        "dew_point",
+				"Derived":        "dew_point",
+			},
+		},
+	}
+)
+
+var (
+	wantSingle = models.CreateMeasurementReq{
+		SensorName:  "node",
+		Timestamp:   ts,
+		MessageID:   ptr("m1"),
+		Measurement: ptr("temperature"),
+		Parameter:   ptr("BME280"),
+		Value:       21.5,
+		Unit:        ptr("°C"),
+	}
+	wantMulti = models.CreateMeasurementReq{
+		SensorName: "node",
+		Measurements: []models.MeasurementValue{
+			{Measurement: "temperature", Value: 21.5, Unit: ptr("°C")},
+			{Measurement: "humidity", Parameter: ptr("BME280"), Value: 48},
+		},
+	}
+	wantOne = models.CreateMeasurementReq{
+		SensorName:   "node",
+		Measurements: []models.MeasurementValue{{Measurement: "temperature", Value: 21.5}},
+	}
+)
+
+// Protobuf forms of the payloads above. Internal fields have no field
+// number in proto/measurement.proto, so they can only arrive as unknown
+// fields.
+var (
+	singleProto = protoMsg(nil).
+			str(reqSensorName, "node").
+			varint(reqTimestampUnixMs, uint64(ts.UnixMilli())).
+			str(reqMessageID, "m1").
+			str(reqMeasurement, "temperature").
+			str(reqParameter, "BME280").
+			double(reqValue, 21.5).
+			str(reqUnit, "°C")
+	multiProto = protoMsg(nil).
+			str(reqSensorName, "node").
+			msg(reqMeasurements, protoMsg(nil).str(valMeasurement, "temperature").double(valValue, 21.5).str(valUnit, "°C")).
+			msg(reqMeasurements, protoMsg(nil).str(valMeasurement, "humidity").str(valParameter, "BME280").double(valValue, 48))
+	unknownProto = protoMsg(nil).
+			str(reqSensorName, "node").
+			str(20, "1.2.0").
+			varint(21, 67).
+			msg(reqMeasurements, protoMsg(nil).
+				str(valMeasurement, "temperature").
+				double(valValue, 21.5).
+				double(5, 99).
+				str(6, "outlier").
+				varint(7, 7).
+				msg(8, protoMsg(nil).str(1, "dew_point")))
+)
+
+func mustJSON(t *testing.T, v any) []byte {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func mustCBOR(t *testing.T, v any) []byte {
+	t.Helper()
+	b, err := cbor.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDecodeCreateMeasurementReq(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		want        models.CreateMeasurementReq
+		wantErr     error
+	}{
+		{"json single", MediaTypeJSON, mustJSON(t, singleMap), wantSingle, nil},
+		{"json multi", MediaTypeJSON, mustJSON(t, multiMap), wantMulti, nil},
+		{"json without content type", "", mustJSON(t, multiMap), wantMulti, nil},
+		{"json unknown fields", MediaTypeJSON, mustJSON(t, unknownMap), wantOne, nil},
+		{"json internal fields", MediaTypeJSON, mustJSON(t, internalMap), wantOne, nil},
+		{"json truncated", MediaTypeJSON, mustJSON(t, multiMap)[:30], models.CreateMeasurementReq{}, models.ErrBadPayload},
+
+		{"cbor single", MediaTypeCBOR, mustCBOR(t, singleMap), wantSingle, nil},
+		{"cbor multi", MediaTypeCBOR, mustCBOR(t, multiMap), wantMulti, nil},
+		{"cbor unknown fields", MediaTypeCBOR, mustCBOR(t, unknownMap), wantOne, nil},
+		{"cbor internal fields", MediaTypeCBOR, mustCBOR(t, internalMap), wantOne, nil},
+		{"cbor truncated", MediaTypeCBOR, mustCBOR(t, multiMap)[:30], models.CreateMeasurementReq{}, models.ErrBadPayload},
+
+		{"protobuf single", MediaTypeProtobuf, singleProto, wantSingle, nil},
+		{"protobuf multi", MediaTypeProtobuf, multiProto, wantMulti, nil},
+		{"protobuf alias", "application/protobuf", multiProto, wantMulti, nil},
+		{"protobuf unknown and internal fields", MediaTypeProtobuf, unknownProto, wantOne, nil},
+		{"protobuf truncated message", MediaTypeProtobuf, multiProto[:len(multiProto)-3], models.CreateMeasurementReq{}, models.ErrBadPayload},
+		{"protobuf truncated value", MediaTypeProtobuf, singleProto[:len(singleProto)-len("°C")-6], models.CreateMeasurementReq{}, models.ErrBadPayload},
+		{"protobuf too large", MediaTypeProtobuf, protoMsg(nil).str(reqSensorName, string(make([]byte, maxBinaryBody))), models.CreateMeasurementReq{}, models.ErrBadPayload},
+
+		{"unsupported", "application/xml", []byte("<x/>"), models.CreateMeasurementReq{}, ErrUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeCreateMeasurementReq(tt.contentType, bytes.NewReader(tt.body))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !got.Timestamp.Equal(tt.want.Timestamp) {
+				t.Errorf("timestamp = %v, want %v", got.Timestamp, tt.want.Timestamp)
+			}
+			got.Timestamp, tt.want.Timestamp = time.Time{}, time.Time{}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+			for i, v := range got.Measurements {
+				if v.Flag != nil || v.RawValue != nil || v.RawUnit != nil || v.CalibrationID != nil || v.Derived != nil {
+					t.Errorf("measurements[%d] has internal fields set: %+v", i, v)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package codec
+
+import (
+	"fmt"
+	"math"
+	"sensor/cmd/api/models"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of proto/measurement.proto. The messages are small and
+// stable, so they are decoded directly from the wire format instead of
+// through generated code.
+const (
+	reqSensorName      = 1
+	reqTimestampUnixMs = 2
+	reqMeasurement     = 3
+	reqParameter       = 4
+	reqValue           = 5
+	reqUnit            = 6
+	reqMeasurements    = 7
+	reqMessageID       = 8
+
+	valMeasurement = 1
+	valParameter   = 2
+	valValue       = 3
+	valUnit        = 4
+)
+
+func unmarshalProto(b []byte) (models.CreateMeasurementReq, error) {
+	var req models.CreateMeasurementReq
+	err := walkProto(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == reqSensorName && typ == protowire.BytesType:
+			s, n := protowire.ConsumeString(b)
+			req.SensorName = s
+			return n, nil
+		case num == reqTimestampUnixMs && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if ms := int64(v); ms != 0 {
+				req.Timestamp = time.UnixMilli(ms).UTC()
+			}
+			return n, nil
+		case num == reqMeasurement && typ == protowire.BytesType:
+			return consumeOptionalString(b, &req.Measurement)
+		case num == reqParameter && typ == protowire.BytesType:
+			return consumeOptionalString(b, &req.Parameter)
+		case num == reqValue && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			req.Value = math.Float64frombits(v)
+			return n, nil
+		case num == reqUnit && typ == protowire.BytesType:
+			return consumeOptionalString(b, &req.Unit)
+		case num == reqMessageID && typ == protowire.BytesType:
+			return consumeOptionalString(b, &req.MessageID)
+		case num == reqMeasurements && typ == protowire.BytesType:
+			msg, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			v, err := unmarshalProtoValue(msg)
+			if err != nil {
+				return 0, fmt.Errorf("measurements[%d]: %w", len(req.Measurements), err)
+			}
+			req.Measurements = append(req.Measurements, v)
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+	return req, err
+}
+
+func unmarshalProtoValue(b []byte) (models.MeasurementValue, error) {
+	var v models.MeasurementValue
+	err := walkProto(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == valMeasurement && typ == protowire.BytesType:
+			s, n := protowire.ConsumeString(b)
+			v.Measurement = s
+			return n, nil
+		case num == valParameter && typ == protowire.BytesType:
+			return consumeOptionalString(b, &v.Parameter)
+		case num == valValue && typ == protowire.Fixed64Type:
+			bits, n := protowire.ConsumeFixed64(b)
+			v.Value = math.Float64frombits(bits)
+			return n, nil
+		case num == valUnit && typ == protowire.BytesType:
+			return consumeOptionalString(b, &v.Unit)
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+	return v, err
+}
+
+// walkProto calls field for every field of a message. field consumes the
+// value and returns its length, or a negative protowire error code.
+// Unknown fields are skipped, as required for forward compatibility.
+func walkProto(b []byte, field func(num protowire.Number, typ protowire.Type, b []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		n, err := field(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return fmt.Errorf("field %d: %w", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func consumeOptionalString(b []byte, dst **string) (int, error) {
+	s, n := protowire.ConsumeString(b)
+	if n >= 0 {
+		*dst = &s
+	}
+	return n, nil
+}
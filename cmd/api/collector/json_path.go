@@ -0,0 +1,117 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrPathNotFound = errors.New("path not found")
+
+// step is one element of a path: an object key or, when index >= 0, an
+// array index.
+type step struct {
+	key   string
+	index int
+}
+
+// Path locates a value in a decoded JSON document. Keys are separated by
+// dots, array elements are written [n] and keys that contain dots or
+// brackets are quoted, e.g. StatusSNS.SDS0X1["PM2.5"] or sensors[0].value.
+// A leading "$." is allowed.
+type Path struct {
+	raw   string
+	steps []step
+}
+
+func ParsePath(s string) (Path, error) {
+	p := Path{raw: s}
+	rest := strings.TrimPrefix(strings.TrimPrefix(s, "$"), ".")
+	if rest == "" {
+		return Path{}, fmt.Errorf("empty path")
+	}
+	for len(rest) > 0 {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			if rest == "" || rest[0] == '.' || rest[0] == '[' {
+				return Path{}, fmt.Errorf("path %q: empty key", s)
+			}
+		case '[':
+			if len(rest) > 1 && (rest[1] == '"' || rest[1] == '\'') {
+				// A quoted key ends at the matching quote and may contain
+				// dots and brackets.
+				closing := strings.Index(rest[2:], string(rest[1])+"]")
+				if closing < 0 {
+					return Path{}, fmt.Errorf("path %q: unterminated quoted key", s)
+				}
+				p.steps = append(p.steps, step{key: rest[2 : 2+closing], index: -1})
+				rest = rest[2+closing+2:]
+				continue
+			}
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return Path{}, fmt.Errorf("path %q: missing ]", s)
+			}
+			n, err := strconv.Atoi(rest[1:end])
+			if err != nil || n < 0 {
+				return Path{}, fmt.Errorf("path %q: invalid index %s", s, rest[:end+1])
+			}
+			p.steps = append(p.steps, step{index: n})
+			rest = rest[end+1:]
+		default:
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			p.steps = append(p.steps, step{key: rest[:end], index: -1})
+			rest = rest[end:]
+		}
+	}
+	return p, nil
+}
+
+func (p Path) String() string {
+	return p.raw
+}
+
+// Number returns the value at the path as a number. Numeric strings are
+// parsed and booleans count as 0 or 1.
+func (p Path) Number(doc any) (float64, error) {
+	v := doc
+	for _, s := range p.steps {
+		if s.index >= 0 {
+			arr, ok := v.([]any)
+			if !ok || s.index >= len(arr) {
+				return 0, fmt.Errorf("%w: %s", ErrPathNotFound, p.raw)
+			}
+			v = arr[s.index]
+			continue
+		}
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return 0, fmt.Errorf("%w: %s", ErrPathNotFound, p.raw)
+		}
+		if v, ok = obj[s.key]; !ok {
+			return 0, fmt.Errorf("%w: %s", ErrPathNotFound, p.raw)
+		}
+	}
+
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %q is not a number", p.raw, v)
+		}
+		return f, nil
+	}
+	return 0, fmt.Errorf("%s: value is not a number", p.raw)
+}
@@ -0,0 +1,386 @@
+// Package collector polls devices that expose their readings over HTTP
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sensor/cmd/api/ingest"
+	"sensor/cmd/api/models"
+	"sensor/cmd/api/storage"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MinInterval     = 5 * time.Second
+	maxBackoff      = 15 * time.Minute
+	fetchTimeout    = 10 * time.Second
+	maxResponseSize = 1 << 20
+)
+
+// Health states of a target.
+const (
+	StatusPending = "pending"
+	StatusOK      = "ok"
+	// StatusPartial means the device answered but some mapped paths
+	// were missing; the values that were found are stored.
+	StatusPartial = "partial"
+	StatusFailing = "failing"
+)
+
+var ErrTargetNotFound = errors.New("collector target not found")
+
+// Health describes the recent polls of a target. After a failed poll the
+// next one waits BackoffSeconds instead of the target interval, doubling
+// with every further failure up to 15 minutes.
+type Health struct {
+	Status              string     `json:"status"`
+	Polls               uint64     `json:"polls"`
+	Failures            uint64     `json:"failures"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastPollAt          *time.Time `json:"last_poll_at,omitempty"`
+	LastSuccessAt       *time.Time `json:"last_success_at,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastErrorAt         *time.Time `json:"last_error_at,omitempty"`
+	BackoffSeconds      float64    `json:"backoff_seconds"`
+	NextPollAt          time.Time  `json:"next_poll_at"`
+}
+
+// TargetStatus is a target together with its health.
+type TargetStatus struct {
+	storage.CollectorTarget
+	Health Health `json:"health"`
+}
+
+// Validate checks a target before it is persisted.
+func Validate(t *storage.CollectorTarget) error {
+	if len(t.SensorID) == 0 {
+		return fmt.Errorf("sensor_id is required")
+	}
+	u, err := url.Parse(t.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	if time.Duration(t.IntervalSeconds)*time.Second < MinInterval {
+		return fmt.Errorf("interval_seconds must be at least %d", int(MinInterval.Seconds()))
+	}
+	if len(t.Mappings) == 0 {
+		return fmt.Errorf("at least one mapping is required")
+	}
+	for n, m := range t.Mappings {
+		if len(m.Measurement) == 0 {
+			return fmt.Errorf("mappings[%d]: measurement is required", n)
+		}
+		if _, err := ParsePath(m.Path); err != nil {
+			return fmt.Errorf("mappings[%d]: %w", n, err)
+		}
+	}
+	return nil
+}
+
+type entry struct {
+	target storage.CollectorTarget
+	paths  []Path
+	stop   chan struct{}
+	// health is guarded by Scheduler.mu.
+	health Health
+	// pollMu serializes scheduled polls and polls requested through the
+	// API.
+	pollMu sync.Mutex
+}
+
+// Scheduler polls every target on its own interval and ingests the mapped
+// values like POST /api/measurements/{sensor_id}.
+type Scheduler struct {
+	infoLog  *log.Logger
+	errorLog *log.Logger
+	ingestor *ingest.Ingestor
+	client   *http.Client
+
+	mu      sync.Mutex
+	entries map[int64]*entry
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	started bool
+}
+
+func NewScheduler(infoLog *log.Logger, errorLog *log.Logger, ingestor *ingest.Ingestor) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		infoLog:  infoLog,
+		errorLog: errorLog,
+		ingestor: ingestor,
+		client:   &http.Client{Timeout: fetchTimeout},
+		entries:  make(map[int64]*entry),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Load schedules the targets read from storage.
+func (s *Scheduler) Load(targets []storage.CollectorTarget) error {
+	for _, t := range targets {
+		if err := s.Add(t); err != nil {
+			return fmt.Errorf("collector target %d: %w", t.ID, err)
+		}
+	}
+	return nil
+}
+
+// Start begins polling. Targets added later start right away.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started = true
+	for _, e := range s.entries {
+		s.launch(e)
+	}
+	s.infoLog.Printf("Collector started with %d targets", len(s.entries))
+}
+
+// Close stops polling and waits for polls in progress.
+func (s *Scheduler) Close() {
+	s.cancel()
+	s.wg.Wait()
+	s.infoLog.Println("Collector stopped")
+}
+
+// Add schedules a target. Its first poll is due immediately.
+func (s *Scheduler) Add(t storage.CollectorTarget) error {
+	paths := make([]Path, len(t.Mappings))
+	for n, m := range t.Mappings {
+		p, err := ParsePath(m.Path)
+		if err != nil {
+			return err
+		}
+		paths[n] = p
+	}
+	e := &entry{
+		target: t,
+		paths:  paths,
+		stop:   make(chan struct{}),
+		health: Health{Status: StatusPending, NextPollAt: time.Now().UTC()},
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.entries[t.ID]; ok {
+		close(old.stop)
+	}
+	s.entries[t.ID] = e
+	if s.started {
+		s.launch(e)
+	}
+	return nil
+}
+
+// Remove stops polling a target and reports whether it was scheduled.
+func (s *Scheduler) Remove(id int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[id]
+	if ok {
+		close(e.stop)
+		delete(s.entries, id)
+	}
+	return ok
+}
+
+// List returns every target with its health, ordered by id.
+func (s *Scheduler) List() []TargetStatus {
+	s.mu.Lock()
+	out := make([]TargetStatus, 0, len(s.entries))
+	for _, e := range s.entries {
+		out = append(out, e.status())
+	}
+	s.mu.Unlock()
+	sort.Slice(out, func(a, b int) bool { return out[a].ID < out[b].ID })
+	return out
+}
+
+func (s *Scheduler) Get(id int64) (TargetStatus, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[id]
+	if !ok {
+		return TargetStatus{}, false
+	}
+	return e.status(), true
+}
+
+// PollNow polls a target right away, outside of its schedule, and returns
+// its health afterwards.
+func (s *Scheduler) PollNow(ctx context.Context, id int64) (TargetStatus, error) {
+	s.mu.Lock()
+	e, ok := s.entries[id]
+	s.mu.Unlock()
+	if !ok {
+		return TargetStatus{}, ErrTargetNotFound
+	}
+	s.poll(ctx, e, false)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return e.status(), nil
+}
+
+func (s *Scheduler) launch(e *entry) {
+	s.wg.Add(1)
+	go s.run(e)
+}
+
+func (s *Scheduler) run(e *entry) {
+	defer s.wg.Done()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-e.stop:
+			return
+		case <-timer.C:
+		}
+		timer.Reset(s.poll(s.ctx, e, true))
+	}
+}
+
+// poll fetches the target once, ingests what it found and returns the
+// delay until the next poll. Polls outside of the schedule leave the next
+// poll time as it is.
+func (s *Scheduler) poll(ctx context.Context, e *entry, scheduled bool) time.Duration {
+	e.pollMu.Lock()
+	defer e.pollMu.Unlock()
+
+	t := e.target
+	now := time.Now().UTC()
+	missing, err := s.collect(ctx, e)
+
+	interval := time.Duration(t.IntervalSeconds) * time.Second
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := &e.health
+	h.Polls++
+	h.LastPollAt = &now
+	delay := interval
+	switch {
+	case err != nil:
+		h.Failures++
+		h.ConsecutiveFailures++
+		h.Status = StatusFailing
+		h.LastError = err.Error()
+		h.LastErrorAt = &now
+		delay = backoff(interval, h.ConsecutiveFailures)
+		if h.ConsecutiveFailures == 1 {
+			s.errorLog.Printf("Collector target %d (%s) failing: %v", t.ID, t.URL, err)
+		}
+	default:
+		if h.ConsecutiveFailures > 0 {
+			s.infoLog.Printf("Collector target %d (%s) recovered after %d failures", t.ID, t.URL, h.ConsecutiveFailures)
+		}
+		h.ConsecutiveFailures = 0
+		h.LastSuccessAt = &now
+		h.Status = StatusOK
+		if len(missing) > 0 {
+			h.Status = StatusPartial
+			h.LastError = fmt.Sprintf("%v: %s", ErrPathNotFound, strings.Join(missing, ", "))
+			h.LastErrorAt = &now
+		}
+	}
+	if !scheduled {
+		return delay
+	}
+	h.BackoffSeconds = 0
+	if delay != interval {
+		h.BackoffSeconds = delay.Seconds()
+	}
+	h.NextPollAt = now.Add(delay)
+	return delay
+}
+
+// collect fetches the target and ingests the mapped values. It returns
+// the paths that were not found in the response.
+func (s *Scheduler) collect(ctx context.Context, e *entry) ([]string, error) {
+	t := e.target
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("device answered %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxResponseSize {
+		return nil, fmt.Errorf("response exceeds %d bytes", maxResponseSize)
+	}
+	var doc any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("response is not JSON: %w", err)
+	}
+
+	sensorName := t.SensorName
+	if sensorName == "" {
+		sensorName = t.SensorID
+	}
+	reading := models.CreateMeasurementReq{SensorName: sensorName}
+	var missing []string
+	for n, m := range t.Mappings {
+		value, err := e.paths[n].Number(doc)
+		if errors.Is(err, ErrPathNotFound) {
+			missing = append(missing, m.Path)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		reading.Measurements = append(reading.Measurements, models.MeasurementValue{
+			Measurement: m.Measurement,
+			Parameter:   m.Parameter,
+			Value:       value,
+			Unit:        m.Unit,
+		})
+	}
+	if len(reading.Measurements) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrPathNotFound, strings.Join(missing, ", "))
+	}
+	if _, err := s.ingestor.Ingest(ctx, t.SensorID, &reading); err != nil {
+		return nil, err
+	}
+	return missing, nil
+}
+
+// status must be called with Scheduler.mu held.
+func (e *entry) status() TargetStatus {
+	return TargetStatus{CollectorTarget: e.target, Health: e.health}
+}
+
+// backoff doubles interval for every consecutive failure after the first,
+// up to maxBackoff or interval, whichever is longer.
+func backoff(interval time.Duration, failures int) time.Duration {
+	limit := max(maxBackoff, interval)
+	delay := interval
+	for n := 1; n < failures && delay < limit; n++ {
+		delay *= 2
+	}
+	return min(delay, limit)
+}
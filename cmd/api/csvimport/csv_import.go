@@ -0,0 +1,322 @@
+// Package csvimport loads historical measurements from CSV files
+package csvimport
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sensor/cmd/api/ingest"
+	"sensor/cmd/api/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FieldTimestamp   = "timestamp"
+	FieldSensorID    = "sensor_id"
+	FieldSensorName  = "sensor_name"
+	FieldMeasurement = "measurement"
+	FieldParameter   = "parameter"
+	FieldValue       = "value"
+	FieldUnit        = "unit"
+)
+
+var fields = []string{FieldTimestamp, FieldSensorID, FieldSensorName, FieldMeasurement, FieldParameter, FieldValue, FieldUnit}
+
+const maxRowErrors = 1000
+
+// timeLayouts are tried in order when Options.TimeFormat is empty. Values
+// without a zone are read as UTC. Plain integers are unix seconds.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"02.01.2006 15:04:05",
+	"02.01.2006 15:04",
+}
+
+type Options struct {
+	// Columns maps a field to the CSV header of the column that holds it.
+	// Fields that are not mapped use the header with the field's own name.
+	Columns map[string]string
+	// Defaults holds constant values for fields that have no column or an
+	// empty cell, e.g. the sensor_id of a file exported from one device.
+	Defaults   map[string]string
+	Delimiter  rune
+	TimeFormat string
+	BatchSize  int
+	DryRun     bool
+}
+
+type RowError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// Report summarises an import. In a dry run Accepted counts rows that
+// passed validation and nothing is written.
+type Report struct {
+	DryRun          bool       `json:"dry_run"`
+	Rows            int        `json:"rows"`
+	Accepted        int        `json:"accepted"`
+	Rejected        int        `json:"rejected"`
+	Batches         int        `json:"batches"`
+	Duplicates      int        `json:"duplicates"`
+	Quarantined     int        `json:"quarantined"`
+	Errors          []RowError `json:"errors,omitempty"`
+	ErrorsTruncated bool       `json:"errors_truncated,omitempty"`
+}
+
+func (r *Report) reject(line int, message string) {
+	r.Rejected++
+	if len(r.Errors) >= maxRowErrors {
+		r.ErrorsTruncated = true
+		return
+	}
+	r.Errors = append(r.Errors, RowError{Line: line, Message: message})
+}
+
+// ParseAssignments turns "field=value" pairs into a map, rejecting unknown
+// fields. It is used for both column mappings and defaults.
+func ParseAssignments(pairs []string) (map[string]string, error) {
+	out := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		field, value, ok := strings.Cut(pair, "=")
+		if !ok || len(value) == 0 {
+			return nil, fmt.Errorf("expected field=value, got %q", pair)
+		}
+		if !isField(field) {
+			return nil, fmt.Errorf("unknown field %q, expected one of %s", field, strings.Join(fields, ", "))
+		}
+		out[field] = value
+	}
+	return out, nil
+}
+
+func isField(name string) bool {
+	for _, f := range fields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Import reads CSV rows from src and stores them through bulk in
+// transactions of Options.BatchSize rows. Row problems are reported in the
+// returned Report; an error is returned only when the file cannot be read
+// or storing fails, in which case the rows of the current batch are lost.
+func Import(ctx context.Context, src io.Reader, bulk *ingest.Bulk, opts Options) (Report, error) {
+	report := Report{DryRun: opts.DryRun}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+
+	reader := csv.NewReader(src)
+	if opts.Delimiter != 0 {
+		reader.Comma = opts.Delimiter
+	}
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return report, fmt.Errorf("read csv header: %w", err)
+	}
+	columns, err := resolveColumns(header, opts)
+	if err != nil {
+		return report, err
+	}
+
+	defer bulk.Rollback()
+	pending := make([]int, 0, opts.BatchSize)
+	commit := func() error {
+		if bulk.Len() == 0 {
+			return nil
+		}
+		if err := bulk.Commit(ctx); err != nil {
+			for _, line := range pending {
+				report.reject(line, "batch commit failed")
+			}
+			return err
+		}
+		report.Accepted += len(pending)
+		report.Batches++
+		report.Duplicates = bulk.Duplicates()
+		report.Quarantined = bulk.Quarantined()
+		pending = pending[:0]
+		return nil
+	}
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				report.Rows++
+				report.reject(parseErr.Line, parseErr.Err.Error())
+				continue
+			}
+			return report, err
+		}
+		report.Rows++
+		line, _ := reader.FieldPos(0)
+
+		row, err := buildLine(record, columns, opts)
+		if err != nil {
+			report.reject(line, err.Error())
+			continue
+		}
+
+		if opts.DryRun {
+			if err := bulk.Check(&row); err != nil {
+				report.reject(line, err.Error())
+				continue
+			}
+			report.Accepted++
+			continue
+		}
+
+		if err := bulk.Add(ctx, &row); err != nil {
+			if errors.Is(err, models.ErrBadPayload) {
+				report.reject(line, err.Error())
+				continue
+			}
+			report.reject(line, "storage error")
+			for _, l := range pending {
+				report.reject(l, "batch rolled back")
+			}
+			return report, err
+		}
+		pending = append(pending, line)
+		if bulk.Len() >= opts.BatchSize {
+			if err := commit(); err != nil {
+				return report, err
+			}
+		}
+	}
+	if err := commit(); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// resolveColumns finds the index of every mapped field in the header.
+// Fields without a column are -1 and rely on Options.Defaults.
+func resolveColumns(header []string, opts Options) (map[string]int, error) {
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		index[strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))] = i
+	}
+
+	columns := make(map[string]int, len(fields))
+	for _, field := range fields {
+		name, mapped := opts.Columns[field]
+		if !mapped {
+			name = field
+		}
+		i, ok := index[name]
+		if !ok {
+			if mapped {
+				return nil, fmt.Errorf("column %q for %s not found in header", name, field)
+			}
+			i = -1
+		}
+		columns[field] = i
+	}
+
+	for _, field := range []string{FieldTimestamp, FieldSensorID, FieldMeasurement, FieldValue} {
+		if columns[field] < 0 && len(opts.Defaults[field]) == 0 {
+			return nil, fmt.Errorf("no column or default for required field %s", field)
+		}
+	}
+	return columns, nil
+}
+
+func buildLine(record []string, columns map[string]int, opts Options) (models.BulkMeasurementLine, error) {
+	get := func(field string) string {
+		if i := columns[field]; i >= 0 && i < len(record) {
+			if v := strings.TrimSpace(record[i]); len(v) != 0 {
+				return v
+			}
+		}
+		return opts.Defaults[field]
+	}
+	optional := func(field string) *string {
+		v := get(field)
+		if len(v) == 0 {
+			return nil
+		}
+		return &v
+	}
+
+	var line models.BulkMeasurementLine
+	line.SensorID = get(FieldSensorID)
+	line.SensorName = get(FieldSensorName)
+	if len(line.SensorName) == 0 {
+		line.SensorName = line.SensorID
+	}
+
+	ts, err := parseTimestamp(get(FieldTimestamp), opts.TimeFormat)
+	if err != nil {
+		return line, err
+	}
+	line.Timestamp = ts
+
+	value, err := parseValue(get(FieldValue))
+	if err != nil {
+		return line, err
+	}
+	measurement := get(FieldMeasurement)
+	line.Measurement = &measurement
+	line.Parameter = optional(FieldParameter)
+	line.Unit = optional(FieldUnit)
+	line.Value = value
+	return line, nil
+}
+
+func parseTimestamp(s, layout string) (time.Time, error) {
+	if len(s) == 0 {
+		return time.Time{}, errors.New("timestamp is empty")
+	}
+	if len(layout) != 0 {
+		ts, err := time.ParseInLocation(layout, s, time.UTC)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("timestamp %q does not match %q", s, layout)
+		}
+		return ts.UTC(), nil
+	}
+	if seconds, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	for _, l := range timeLayouts {
+		if ts, err := time.ParseInLocation(l, s, time.UTC); err == nil {
+			return ts.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised timestamp %q", s)
+}
+
+// parseValue accepts a decimal comma as written by spreadsheets in many
+// locales.
+func parseValue(s string) (float64, error) {
+	if len(s) == 0 {
+		return 0, errors.New("value is empty")
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil && strings.Count(s, ",") == 1 && !strings.Contains(s, ".") {
+		v, err = strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("value %q is not a number", s)
+	}
+	return v, nil
+}
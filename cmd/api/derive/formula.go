@@ -0,0 +1,256 @@
+// Package derive computes measurements from other readings of the same request
+package derive
+
+import (
+	"fmt"
+	"math"
+	"sensor/cmd/api/models"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of formula. Temperatures are in °C and relative humidity in %,
+// the canonical units of temperature and humidity.
+const (
+	// KindDewPoint is the dew point in °C (Magnus formula) from
+	// temperature and relative humidity.
+	KindDewPoint = "dew_point"
+	// KindAbsoluteHumidity is the water vapour density in g/m3 from
+	// temperature and relative humidity.
+	KindAbsoluteHumidity = "absolute_humidity"
+	// KindHeatIndex is the NOAA heat index in °C from temperature and
+	// relative humidity.
+	KindHeatIndex = "heat_index"
+	// KindRatio divides the first input by the second, e.g. pm25 by pm10.
+	KindRatio = "ratio"
+	// KindHumidityCorrectedPM removes the water taken up by particles at
+	// high humidity from an optical PM reading (κ-Köhler, Crilley et al.
+	// 2018) using the first input as PM and the second as relative
+	// humidity.
+	KindHumidityCorrectedPM = "humidity_corrected_pm"
+)
+
+// DefaultKappa is the hygroscopicity used by KindHumidityCorrectedPM when a
+// formula does not set one.
+const DefaultKappa = 0.4
+
+// Formula computes the measurement of the same name from two other
+// measurements of a request.
+type Formula struct {
+	Measurement string    `json:"measurement"`
+	Kind        string    `json:"kind"`
+	Inputs      []string  `json:"inputs"`
+	Kappa       *float64  `json:"kappa,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+var climateInputs = []string{"temperature", "humidity"}
+
+// DefaultFormulas are stored when the formula table is created.
+var DefaultFormulas = []Formula{
+	{Measurement: "dew_point", Kind: KindDewPoint, Inputs: climateInputs},
+	{Measurement: "absolute_humidity", Kind: KindAbsoluteHumidity, Inputs: climateInputs},
+	{Measurement: "heat_index", Kind: KindHeatIndex, Inputs: climateInputs},
+}
+
+// Validate checks a formula before it is persisted. Formulas of a climate
+// kind without inputs read temperature and humidity.
+func (f *Formula) Validate() error {
+	if len(f.Measurement) == 0 {
+		return fmt.Errorf("measurement is required")
+	}
+	switch f.Kind {
+	case KindDewPoint, KindAbsoluteHumidity, KindHeatIndex:
+		if len(f.Inputs) == 0 {
+			f.Inputs = slices.Clone(climateInputs)
+		}
+	case KindRatio, KindHumidityCorrectedPM:
+	default:
+		return fmt.Errorf("kind must be '%s', '%s', '%s', '%s' or '%s'", KindDewPoint, KindAbsoluteHumidity, KindHeatIndex, KindRatio, KindHumidityCorrectedPM)
+	}
+	if len(f.Inputs) != 2 || len(f.Inputs[0]) == 0 || len(f.Inputs[1]) == 0 {
+		return fmt.Errorf("inputs must name two measurements")
+	}
+	if slices.Contains(f.Inputs, f.Measurement) {
+		return fmt.Errorf("measurement must not be one of its inputs")
+	}
+	if f.Kappa != nil {
+		if f.Kind != KindHumidityCorrectedPM {
+			return fmt.Errorf("kappa only applies to kind '%s'", KindHumidityCorrectedPM)
+		}
+		if *f.Kappa <= 0 {
+			return fmt.Errorf("kappa must be positive")
+		}
+	}
+	return nil
+}
+
+// String describes the formula as stored with derived readings, e.g.
+// "dew_point(temperature,humidity)".
+func (f *Formula) String() string {
+	return fmt.Sprintf("%s(%s)", f.Kind, strings.Join(f.Inputs, ","))
+}
+
+// unit returns the unit of the derived value given the unit of the first
+// input.
+func (f *Formula) unit(first *string) *string {
+	var unit string
+	switch f.Kind {
+	case KindDewPoint, KindHeatIndex:
+		unit = "°C"
+	case KindAbsoluteHumidity:
+		unit = "g/m3"
+	case KindHumidityCorrectedPM:
+		return first
+	default:
+		return nil
+	}
+	return &unit
+}
+
+// compute applies the formula to the values of its two inputs. It reports
+// false when the inputs are out of the formula's range.
+func (f *Formula) compute(a, b float64) (float64, bool) {
+	var v float64
+	switch f.Kind {
+	case KindDewPoint:
+		if b <= 0 {
+			return 0, false
+		}
+		v = dewPoint(a, b)
+	case KindAbsoluteHumidity:
+		v = absoluteHumidity(a, b)
+	case KindHeatIndex:
+		v = heatIndex(a, b)
+	case KindRatio:
+		if b == 0 {
+			return 0, false
+		}
+		v = a / b
+	case KindHumidityCorrectedPM:
+		kappa := DefaultKappa
+		if f.Kappa != nil {
+			kappa = *f.Kappa
+		}
+		v = humidityCorrectedPM(a, b, kappa)
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, false
+	}
+	return v, true
+}
+
+// Formulas holds the active formulas keyed by derived measurement. It is
+// read on every ingested request and updated through the formulas API.
+type Formulas struct {
+	mu       sync.RWMutex
+	formulas map[string]Formula
+}
+
+func NewFormulas() *Formulas {
+	return &Formulas{formulas: make(map[string]Formula)}
+}
+
+func (r *Formulas) Load(formulas []Formula) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.formulas = make(map[string]Formula, len(formulas))
+	for _, f := range formulas {
+		r.formulas[f.Measurement] = f
+	}
+}
+
+func (r *Formulas) Set(f Formula) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.formulas[f.Measurement] = f
+}
+
+func (r *Formulas) Delete(measurement string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.formulas, measurement)
+}
+
+func (r *Formulas) Get(measurement string) (Formula, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	f, ok := r.formulas[measurement]
+	return f, ok
+}
+
+func (r *Formulas) List() []Formula {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]Formula, 0, len(r.formulas))
+	for _, f := range r.formulas {
+		out = append(out, f)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Measurement < out[j].Measurement })
+	return out
+}
+
+// Derive returns the readings computed from values by every formula whose
+// inputs are present. Readings of the first input are paired with the
+// reading of the second input that has the same parameter or, failing
+// that, the only reading of the second input; the derived reading takes
+// the parameter of the first. Formulas whose measurement the request
+// already carries are left out.
+func (r *Formulas) Derive(values []models.MeasurementValue) []models.MeasurementValue {
+	byMeasurement := make(map[string][]models.MeasurementValue)
+	for _, v := range values {
+		byMeasurement[v.Measurement] = append(byMeasurement[v.Measurement], v)
+	}
+
+	var derived []models.MeasurementValue
+	for _, f := range r.List() {
+		if _, ok := byMeasurement[f.Measurement]; ok {
+			continue
+		}
+		seconds := byMeasurement[f.Inputs[1]]
+		if len(seconds) == 0 {
+			continue
+		}
+		name := f.String()
+		for _, a := range byMeasurement[f.Inputs[0]] {
+			b, ok := pair(a, seconds)
+			if !ok {
+				continue
+			}
+			value, ok := f.compute(a.Value, b.Value)
+			if !ok {
+				continue
+			}
+			derived = append(derived, models.MeasurementValue{
+				Measurement: f.Measurement,
+				Parameter:   a.Parameter,
+				Value:       value,
+				Unit:        f.unit(a.Unit),
+				Derived:     &name,
+			})
+		}
+	}
+	return derived
+}
+
+func pair(a models.MeasurementValue, candidates []models.MeasurementValue) (models.MeasurementValue, bool) {
+	for _, b := range candidates {
+		if parameterOf(a) == parameterOf(b) {
+			return b, true
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0], true
+	}
+	return models.MeasurementValue{}, false
+}
+
+func parameterOf(v models.MeasurementValue) string {
+	if v.Parameter == nil {
+		return ""
+	}
+	return *v.Parameter
+}
@@ -0,0 +1,62 @@
+package derive
+
+import "math"
+
+// Magnus coefficients over water (Alduchov and Eskridge 1996).
+const (
+	magnusA = 17.625
+	magnusB = 243.04
+)
+
+// saturationVapourPressure returns the saturation vapour pressure in hPa
+// at t °C.
+func saturationVapourPressure(t float64) float64 {
+	return 6.1094 * math.Exp(magnusA*t/(magnusB+t))
+}
+
+func dewPoint(t, rh float64) float64 {
+	gamma := math.Log(rh/100) + magnusA*t/(magnusB+t)
+	return magnusB * gamma / (magnusA - gamma)
+}
+
+// absoluteHumidity returns the water vapour density in g/m3 from the
+// vapour pressure by the ideal gas law (R_v = 461.5 J/(kg·K)).
+func absoluteHumidity(t, rh float64) float64 {
+	vapourPressure := saturationVapourPressure(t) * rh // Pa, from hPa·%
+	return vapourPressure / (461.5 * (t + 273.15)) * 1000
+}
+
+// heatIndex follows the NOAA Weather Prediction Center algorithm: Steadman's
+// simple formula below 80 °F, the Rothfusz regression with its low and
+// high humidity adjustments above.
+func heatIndex(t, rh float64) float64 {
+	f := t*9/5 + 32
+	hi := 0.5 * (f + 61 + (f-68)*1.2 + rh*0.094)
+	if (hi+f)/2 >= 80 {
+		hi = -42.379 + 2.04901523*f + 10.14333127*rh -
+			0.22475541*f*rh - 0.00683783*f*f - 0.05481717*rh*rh +
+			0.00122874*f*f*rh + 0.00085282*f*rh*rh - 0.00000199*f*f*rh*rh
+		switch {
+		case rh < 13 && f >= 80 && f <= 112:
+			hi -= (13 - rh) / 4 * math.Sqrt((17-math.Abs(f-95))/17)
+		case rh > 85 && f >= 80 && f <= 87:
+			hi += (rh - 85) / 10 * (87 - f) / 5
+		}
+	}
+	return (hi - 32) * 5 / 9
+}
+
+// maxWaterActivity keeps the κ-Köhler growth factor finite in saturated
+// air, where optical sensors read fog droplets anyway.
+const maxWaterActivity = 0.99
+
+// humidityCorrectedPM divides pm by the hygroscopic growth factor
+// 1 + (κ/1.65) / (1/aw − 1), where aw is relative humidity as a fraction
+// and 1.65 the assumed particle density in g/cm3.
+func humidityCorrectedPM(pm, rh, kappa float64) float64 {
+	aw := math.Min(math.Max(rh/100, 0), maxWaterActivity)
+	if aw == 0 {
+		return pm
+	}
+	return pm / (1 + (kappa/1.65)/(1/aw-1))
+}
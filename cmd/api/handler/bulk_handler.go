@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sensor/cmd/api/ingest"
+	"sensor/cmd/api/models"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultBulkBatchSize = 500
+	maxBulkBatchSize     = 5000
+	maxBulkLineErrors    = 1000
+	maxBulkLineSize      = 1 << 20
+	bulkReadTimeout      = 10 * time.Minute
+)
+
+type BulkHandler struct {
+	infoLog  *log.Logger
+	errorLog *log.Logger
+	ingestor *ingest.Ingestor
+}
+
+func NewBulkHandler(infoLog *log.Logger, errorLog *log.Logger, ingestor *ingest.Ingestor) *BulkHandler {
+	return &BulkHandler{
+		infoLog:  infoLog,
+		errorLog: errorLog,
+		ingestor: ingestor,
+	}
+}
+
+// bulkResponse reports the outcome of an upload. Every line that is not
+// listed in Errors was accepted. At most maxBulkLineErrors are listed.
+type bulkResponse struct {
+	Accepted        int         `json:"accepted"`
+	Rejected        int         `json:"rejected"`
+	Batches         int         `json:"batches"`
+	Duplicates      int         `json:"duplicates"`
+	Quarantined     int         `json:"quarantined"`
+	Errors          []lineError `json:"errors,omitempty"`
+	ErrorsTruncated bool        `json:"errors_truncated,omitempty"`
+}
+
+func (resp *bulkResponse) reject(line int, message string) {
+	resp.Rejected++
+	if len(resp.Errors) >= maxBulkLineErrors {
+		resp.ErrorsTruncated = true
+		return
+	}
+	resp.Errors = append(resp.Errors, lineError{Line: line, Message: message})
+}
+
+// Create streams newline-delimited JSON, one BulkMeasurementLine per line,
+// and commits it in transactions of ?batch_size= lines. The body is never
+// held in memory as a whole, so gateways can flush large backlogs.
+func (h *BulkHandler) Create(w http.ResponseWriter, r *http.Request) {
+	batchSize := defaultBulkBatchSize
+	if s := r.URL.Query().Get("batch_size"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxBulkBatchSize {
+			http.Error(w, fmt.Sprintf("batch_size must be between 1 and %d", maxBulkBatchSize), http.StatusBadRequest)
+			return
+		}
+		batchSize = n
+	}
+
+	// Large uploads over slow links outlive the server-wide read timeout.
+	if err := http.NewResponseController(w).SetReadDeadline(time.Now().Add(bulkReadTimeout)); err != nil {
+		h.errorLog.Printf("Failed to extend read deadline %v", err)
+	}
+
+	ctx := r.Context()
+	bulk := h.ingestor.NewBulk()
+	defer bulk.Rollback()
+
+	var resp bulkResponse
+	pending := make([]int, 0, batchSize)
+	failPending := func(message string) {
+		for _, line := range pending {
+			resp.reject(line, message)
+		}
+		pending = pending[:0]
+	}
+	commit := func() error {
+		if bulk.Len() == 0 {
+			return nil
+		}
+		if err := bulk.Commit(ctx); err != nil {
+			failPending("batch commit failed")
+			return err
+		}
+		resp.Accepted += len(pending)
+		resp.Batches++
+		resp.Duplicates = bulk.Duplicates()
+		resp.Quarantined = bulk.Quarantined()
+		pending = pending[:0]
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBulkLineSize)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		var line models.BulkMeasurementLine
+		if err := json.Unmarshal(raw, &line); err != nil {
+			resp.reject(lineNo, err.Error())
+			continue
+		}
+		if err := bulk.Add(ctx, &line); err != nil {
+			if errors.Is(err, models.ErrBadPayload) {
+				resp.reject(lineNo, err.Error())
+				continue
+			}
+			h.errorLog.Println(err)
+			resp.reject(lineNo, "storage error")
+			failPending("batch rolled back")
+			h.writeResponse(w, http.StatusInternalServerError, resp)
+			return
+		}
+		pending = append(pending, lineNo)
+
+		if bulk.Len() >= batchSize {
+			if err := commit(); err != nil {
+				h.errorLog.Println(err)
+				h.writeResponse(w, ingestErrorStatus(err), resp)
+				return
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		h.errorLog.Println(err)
+		failPending("upload aborted")
+		resp.reject(lineNo+1, err.Error())
+		h.writeResponse(w, bodyErrorStatus(err), resp)
+		return
+	}
+	if err := commit(); err != nil {
+		h.errorLog.Println(err)
+		h.writeResponse(w, ingestErrorStatus(err), resp)
+		return
+	}
+
+	h.infoLog.Printf("Bulk upload accepted %d rejected %d lines in %d batches", resp.Accepted, resp.Rejected, resp.Batches)
+	h.writeResponse(w, http.StatusOK, resp)
+}
+
+func (h *BulkHandler) writeResponse(w http.ResponseWriter, status int, resp bulkResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		h.errorLog.Println(err)
+	}
+}
@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sensor/cmd/api/calibration"
+	"sensor/cmd/api/ingest"
+	"sensor/cmd/api/storage"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type CalibrationHandler struct {
+	infoLog  *log.Logger
+	errorLog *log.Logger
+	storage  *storage.SQLStorage
+	profiles *calibration.Profiles
+	ingestor *ingest.Ingestor
+}
+
+func NewCalibrationHandler(infoLog *log.Logger, errorLog *log.Logger, storage *storage.SQLStorage, profiles *calibration.Profiles, ingestor *ingest.Ingestor) *CalibrationHandler {
+	return &CalibrationHandler{
+		infoLog:  infoLog,
+		errorLog: errorLog,
+		storage:  storage,
+		profiles: profiles,
+		ingestor: ingestor,
+	}
+}
+
+type calibrationProfilesResponse struct {
+	Items []calibration.Profile `json:"items"`
+}
+
+// List returns the profiles of a sensor, or of one of its measurements,
+// ordered by effective_from.
+func (h *CalibrationHandler) List(w http.ResponseWriter, r *http.Request) {
+	items := h.profiles.List(chi.URLParam(r, pathParamSensorID), chi.URLParam(r, pathParamMeasurement))
+	h.writeJSON(w, http.StatusOK, calibrationProfilesResponse{Items: items})
+}
+
+// Create adds a profile version, e.g. {"kind":"linear","gain":0.9,
+// "offset":-1.5,"effective_from":"2025-01-01T00:00:00Z"}. Without
+// effective_from the profile applies to all readings. Stored readings
+// keep their value until they are recalibrated.
+func (h *CalibrationHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var profile calibration.Profile
+	if err := json.NewDecoder(r.Body).Decode(&profile); err != nil {
+		h.errorLog.Println("Invalid JSON")
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	profile.SensorID = chi.URLParam(r, pathParamSensorID)
+	profile.Measurement = chi.URLParam(r, pathParamMeasurement)
+	if profile.EffectiveFrom.IsZero() {
+		profile.EffectiveFrom = time.Unix(0, 0).UTC()
+	}
+	if err := profile.Validate(); err != nil {
+		h.errorLog.Printf("Invalid calibration profile for %s %s %v", profile.SensorID, profile.Measurement, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stored, err := h.storage.CreateCalibrationProfile(r.Context(), profile)
+	if err != nil {
+		http.Error(w, "Internal Server error", http.StatusInternalServerError)
+		return
+	}
+	h.profiles.Add(stored)
+	h.infoLog.Printf("Added calibration profile %d %s for %s %s from %s", stored.ID, stored.Kind, stored.SensorID, stored.Measurement, stored.EffectiveFrom.Format(time.RFC3339))
+	h.writeJSON(w, http.StatusCreated, stored)
+}
+
+func (h *CalibrationHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	sensorID := chi.URLParam(r, pathParamSensorID)
+	measurement := chi.URLParam(r, pathParamMeasurement)
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+
+	found, err := h.storage.DeleteCalibrationProfile(r.Context(), sensorID, measurement, id)
+	if err != nil {
+		h.errorLog.Printf("Failed to delete calibration profile %d %v", id, err)
+		http.Error(w, "Internal Server error", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	h.profiles.Delete(sensorID, measurement, id)
+	h.infoLog.Printf("Removed calibration profile %d", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Recalibrate recomputes the stored values of readings taken between
+// ?from= and ?to= (RFC 3339, both optional) with the profiles now in effect.
+func (h *CalibrationHandler) Recalibrate(w http.ResponseWriter, r *http.Request) {
+	sensorID := chi.URLParam(r, pathParamSensorID)
+	measurement := chi.URLParam(r, pathParamMeasurement)
+
+	from, to := time.Unix(0, 0), time.Now().UTC()
+	q := r.URL.Query()
+	for name, dst := range map[string]*time.Time{"from": &from, "to": &to} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, name+" must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		*dst = t
+	}
+
+	result, err := h.ingestor.Recalibrate(r.Context(), sensorID, measurement, from, to)
+	if err != nil {
+		h.errorLog.Printf("Recalibration of %s %s failed after %d records %v", sensorID, measurement, result.Updated, err)
+		http.Error(w, "Internal Server error", http.StatusInternalServerError)
+		return
+	}
+	h.infoLog.Printf("Recalibrated %s %s updated %d skipped %d", sensorID, measurement, result.Updated, result.Skipped)
+	h.writeJSON(w, http.StatusOK, result)
+}
+
+func (h *CalibrationHandler) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.errorLog.Println(err)
+	}
+}
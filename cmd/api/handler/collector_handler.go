@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sensor/cmd/api/collector"
+	"sensor/cmd/api/storage"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type CollectorHandler struct {
+	infoLog   *log.Logger
+	errorLog  *log.Logger
+	storage   *storage.SQLStorage
+	scheduler *collector.Scheduler
+}
+
+func NewCollectorHandler(infoLog *log.Logger, errorLog *log.Logger, storage *storage.SQLStorage, scheduler *collector.Scheduler) *CollectorHandler {
+	return &CollectorHandler{
+		infoLog:   infoLog,
+		errorLog:  errorLog,
+		storage:   storage,
+		scheduler: scheduler,
+	}
+}
+
+type collectorTargetsResponse struct {
+	Items []collector.TargetStatus `json:"items"`
+}
+
+// List returns every target with its health.
+func (h *CollectorHandler) List(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, http.StatusOK, collectorTargetsResponse{Items: h.scheduler.List()})
+}
+
+func (h *CollectorHandler) Get(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.targetID(w, r)
+	if !ok {
+		return
+	}
+	target, found := h.scheduler.Get(id)
+	if !found {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, target)
+}
+
+// Create registers a device, e.g. {"sensor_id":"balcony","url":
+// "http://10.0.0.7/cm?cmnd=status%2010","interval_seconds":60,"mappings":
+// [{"path":"StatusSNS.SDS0X1[\"PM2.5\"]","measurement":"pm25"}]}. The first
+// poll starts right away.
+func (h *CollectorHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var target storage.CollectorTarget
+	if err := json.NewDecoder(r.Body).Decode(&target); err != nil {
+		h.errorLog.Println("Invalid JSON")
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := collector.Validate(&target); err != nil {
+		h.errorLog.Printf("Invalid collector target %s %s %v", target.SensorID, target.URL, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stored, err := h.storage.CreateCollectorTarget(r.Context(), target)
+	if err != nil {
+		http.Error(w, "Internal Server error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.scheduler.Add(stored); err != nil {
+		h.errorLog.Printf("Failed to schedule collector target %d %v", stored.ID, err)
+		http.Error(w, "Internal Server error", http.StatusInternalServerError)
+		return
+	}
+	h.infoLog.Printf("Added collector target %d %s for %s every %ds", stored.ID, stored.URL, stored.SensorID, stored.IntervalSeconds)
+	status, _ := h.scheduler.Get(stored.ID)
+	h.writeJSON(w, http.StatusCreated, status)
+}
+
+func (h *CollectorHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.targetID(w, r)
+	if !ok {
+		return
+	}
+	found, err := h.storage.DeleteCollectorTarget(r.Context(), id)
+	if err != nil {
+		h.errorLog.Printf("Failed to delete collector target %d %v", id, err)
+		http.Error(w, "Internal Server error", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	h.scheduler.Remove(id)
+	h.infoLog.Printf("Removed collector target %d", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Poll polls a target immediately and returns its health afterwards. The
+// regular schedule is not affected.
+func (h *CollectorHandler) Poll(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.targetID(w, r)
+	if !ok {
+		return
+	}
+	status, err := h.scheduler.PollNow(r.Context(), id)
+	if errors.Is(err, collector.ErrTargetNotFound) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, status)
+}
+
+func (h *CollectorHandler) targetID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+func (h *CollectorHandler) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.errorLog.Println(err)
+	}
+}
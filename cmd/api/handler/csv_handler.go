@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sensor/cmd/api/csvimport"
+	"sensor/cmd/api/ingest"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const maxCSVUploadMemory = 8 << 20
+
+type CSVHandler struct {
+	infoLog  *log.Logger
+	errorLog *log.Logger
+	ingestor *ingest.Ingestor
+}
+
+func NewCSVHandler(infoLog *log.Logger, errorLog *log.Logger, ingestor *ingest.Ingestor) *CSVHandler {
+	return &CSVHandler{
+		infoLog:  infoLog,
+		errorLog: errorLog,
+		ingestor: ingestor,
+	}
+}
+
+// Import loads a CSV file sent either as the raw body or as the "file"
+// field of a multipart form. Query parameters configure the import:
+// col=field=Header (repeatable) maps columns, default=field=value
+// (repeatable) sets constants, plus delimiter, time_format, batch_size and
+// dry_run=true to only validate. Rows older than max_age are rejected; the
+// import-csv command can load older history.
+func (h *CSVHandler) Import(w http.ResponseWriter, r *http.Request) {
+	opts, err := csvOptionsFromQuery(r)
+	if err != nil {
+		h.errorLog.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := http.NewResponseController(w).SetReadDeadline(time.Now().Add(bulkReadTimeout)); err != nil {
+		h.errorLog.Printf("Failed to extend read deadline %v", err)
+	}
+
+	var src io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(maxCSVUploadMemory); err != nil {
+			http.Error(w, err.Error(), bodyErrorStatus(err))
+			return
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "multipart field 'file' is required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		src = file
+	}
+
+	report, err := csvimport.Import(r.Context(), src, h.ingestor.NewBulk(), opts)
+	status := http.StatusOK
+	if err != nil {
+		h.errorLog.Println(err)
+		status = ingestErrorStatus(err)
+		if status == http.StatusInternalServerError && report.Rows == 0 {
+			status = http.StatusBadRequest
+		}
+	}
+	h.infoLog.Printf("CSV import dry_run=%t rows %d accepted %d rejected %d", report.DryRun, report.Rows, report.Accepted, report.Rejected)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(csvImportResponse{Report: report, Error: errorString(err)}); err != nil {
+		h.errorLog.Println(err)
+	}
+}
+
+type csvImportResponse struct {
+	csvimport.Report
+	Error string `json:"error,omitempty"`
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func csvOptionsFromQuery(r *http.Request) (csvimport.Options, error) {
+	q := r.URL.Query()
+	var opts csvimport.Options
+	var err error
+
+	if opts.Columns, err = csvimport.ParseAssignments(q["col"]); err != nil {
+		return opts, fmt.Errorf("col: %w", err)
+	}
+	if opts.Defaults, err = csvimport.ParseAssignments(q["default"]); err != nil {
+		return opts, fmt.Errorf("default: %w", err)
+	}
+	if d := q.Get("delimiter"); d != "" {
+		if d == `\t` {
+			d = "\t"
+		}
+		if utf8.RuneCountInString(d) != 1 {
+			return opts, errors.New("delimiter must be a single character")
+		}
+		opts.Delimiter, _ = utf8.DecodeRuneInString(d)
+	}
+	opts.TimeFormat = q.Get("time_format")
+	opts.BatchSize = defaultBulkBatchSize
+	if s := q.Get("batch_size"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxBulkBatchSize {
+			return opts, fmt.Errorf("batch_size must be between 1 and %d", maxBulkBatchSize)
+		}
+		opts.BatchSize = n
+	}
+	opts.DryRun = q.Get("dry_run") == "true"
+	return opts, nil
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sensor/cmd/api/ingest"
+)
+
+type DedupHandler struct {
+	infoLog  *log.Logger
+	errorLog *log.Logger
+	stats    *ingest.DedupStats
+}
+
+func NewDedupHandler(infoLog *log.Logger, errorLog *log.Logger, stats *ingest.DedupStats) *DedupHandler {
+	return &DedupHandler{
+		infoLog:  infoLog,
+		errorLog: errorLog,
+		stats:    stats,
+	}
+}
+
+type dedupStatsResponse struct {
+	DuplicateReadings uint64                    `json:"duplicate_readings"`
+	IdempotentReplays uint64                    `json:"idempotent_replays"`
+	Items             []ingest.SensorDedupStats `json:"items"`
+}
+
+// Stats reports per sensor how many readings were dropped as duplicates
+// and how many requests were answered by replaying an Idempotency-Key.
+func (h *DedupHandler) Stats(w http.ResponseWriter, r *http.Request) {
+	resp := dedupStatsResponse{Items: h.stats.Snapshot()}
+	for _, s := range resp.Items {
+		resp.DuplicateReadings += s.DuplicateReadings
+		resp.IdempotentReplays += s.IdempotentReplays
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		h.errorLog.Println(err)
+	}
+}
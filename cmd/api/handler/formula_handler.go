@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sensor/cmd/api/derive"
+	"sensor/cmd/api/storage"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type FormulaHandler struct {
+	infoLog  *log.Logger
+	errorLog *log.Logger
+	storage  *storage.SQLStorage
+	formulas *derive.Formulas
+}
+
+func NewFormulaHandler(infoLog *log.Logger, errorLog *log.Logger, storage *storage.SQLStorage, formulas *derive.Formulas) *FormulaHandler {
+	return &FormulaHandler{
+		infoLog:  infoLog,
+		errorLog: errorLog,
+		storage:  storage,
+		formulas: formulas,
+	}
+}
+
+type formulasResponse struct {
+	Items []derive.Formula `json:"items"`
+}
+
+func (h *FormulaHandler) List(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, http.StatusOK, formulasResponse{Items: h.formulas.List()})
+}
+
+func (h *FormulaHandler) Get(w http.ResponseWriter, r *http.Request) {
+	f, ok := h.formulas.Get(chi.URLParam(r, pathParamMeasurement))
+	if !ok {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, f)
+}
+
+// Update creates or replaces the formula of the derived measurement in the
+// path, e.g. {"kind":"ratio","inputs":["pm25","pm10"]}. Readings stored
+// before keep their value.
+func (h *FormulaHandler) Update(w http.ResponseWriter, r *http.Request) {
+	var f derive.Formula
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		h.errorLog.Println("Invalid JSON")
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	f.Measurement = chi.URLParam(r, pathParamMeasurement)
+	if err := f.Validate(); err != nil {
+		h.errorLog.Printf("Invalid derived formula for '%s' %v", f.Measurement, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stored, err := h.storage.UpsertDerivedFormula(r.Context(), f)
+	if err != nil {
+		http.Error(w, "Internal Server error", http.StatusInternalServerError)
+		return
+	}
+	h.formulas.Set(stored)
+	h.infoLog.Printf("Apply derived formula %s = %s", stored.Measurement, stored.String())
+	h.writeJSON(w, http.StatusOK, stored)
+}
+
+func (h *FormulaHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	measurement := chi.URLParam(r, pathParamMeasurement)
+	found, err := h.storage.DeleteDerivedFormula(r.Context(), measurement)
+	if err != nil {
+		h.errorLog.Printf("Failed to delete derived formula '%s' %v", measurement, err)
+		http.Error(w, "Internal Server error", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	h.formulas.Delete(measurement)
+	h.infoLog.Printf("Removed derived formula for %s", measurement)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *FormulaHandler) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.errorLog.Println(err)
+	}
+}
@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"sensor/cmd/api/ingest"
+	"sensor/cmd/api/storage"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	headerIdempotencyKey = "Idempotency-Key"
+	maxIdempotencyKeyLen = 255
+	// maxIdempotentBody is the largest response kept for replay.
+	maxIdempotentBody = 1 << 20
+)
+
+// Idempotency lets clients retry an ingestion request safely. The first
+// response to a request carrying an Idempotency-Key header is stored and
+// replayed for later requests with the same key on the same path, without
+// running the handler again.
+type Idempotency struct {
+	infoLog  *log.Logger
+	errorLog *log.Logger
+	storage  *storage.SQLStorage
+	writer   *storage.Writer
+	stats    *ingest.DedupStats
+
+	mu       sync.Mutex
+	inFlight map[string]struct{}
+}
+
+func NewIdempotency(infoLog *log.Logger, errorLog *log.Logger, storage *storage.SQLStorage, writer *storage.Writer, stats *ingest.DedupStats) *Idempotency {
+	return &Idempotency{
+		infoLog:  infoLog,
+		errorLog: errorLog,
+		storage:  storage,
+		writer:   writer,
+		stats:    stats,
+		inFlight: make(map[string]struct{}),
+	}
+}
+
+func (h *Idempotency) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(headerIdempotencyKey)
+		if len(key) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+		scope := r.URL.Path
+		sensorID := chi.URLParam(r, pathParamSensorID)
+
+		stored, err := h.storage.GetIdempotentResponse(r.Context(), scope, key)
+		if err != nil {
+			h.errorLog.Printf("Failed to get idempotency key %s %s %v", scope, key, err)
+			http.Error(w, "Internal Server error", http.StatusInternalServerError)
+			return
+		}
+		if stored != nil {
+			h.stats.AddReplay(sensorID)
+			h.infoLog.Printf("Replay response for idempotency key %s %s", scope, key)
+			w.Header().Set("Content-Type", stored.ContentType)
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.Status)
+			_, _ = w.Write(stored.Body)
+			return
+		}
+
+		if !h.acquire(scope, key) {
+			http.Error(w, "a request with this Idempotency-Key is still in progress", http.StatusConflict)
+			return
+		}
+		defer h.release(scope, key)
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		// Server errors are not stored so that a retry can succeed.
+		if rec.status >= http.StatusInternalServerError {
+			return
+		}
+		if rec.overflow {
+			h.errorLog.Printf("Response for idempotency key %s %s is too large to store", scope, key)
+			return
+		}
+		resp := storage.IdempotentResponse{
+			Status:      rec.status,
+			ContentType: w.Header().Get("Content-Type"),
+			Body:        rec.body.Bytes(),
+			CreatedAt:   time.Now().UTC(),
+		}
+		// The client may be gone after a timeout, which is exactly when it
+		// will retry, so the response is saved regardless. It goes through
+		// the writer like the ingestion it follows.
+		err = h.writer.Do(context.WithoutCancel(r.Context()), func(ctx context.Context, tx *storage.Tx) error {
+			return tx.SaveIdempotentResponse(ctx, scope, key, resp)
+		})
+		if err != nil {
+			h.errorLog.Printf("Failed to save idempotency key %s %s %v", scope, key, err)
+		}
+	})
+}
+
+func (h *Idempotency) acquire(scope, key string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	id := scope + "\n" + key
+	if _, busy := h.inFlight[id]; busy {
+		return false
+	}
+	h.inFlight[id] = struct{}{}
+	return true
+}
+
+func (h *Idempotency) release(scope, key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.inFlight, scope+"\n"+key)
+}
+
+// responseRecorder passes a response through while keeping a copy of its
+// status and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if !r.overflow {
+		if r.body.Len()+len(b) > maxIdempotentBody {
+			r.overflow = true
+			r.body.Reset()
+		} else {
+			r.body.Write(b)
+		}
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,200 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sensor/cmd/api/influx"
+	"sensor/cmd/api/ingest"
+	"sensor/cmd/api/models"
+	"strings"
+	"time"
+)
+
+// defaultSensorTags are tried in order when the request does not name the
+// tag that carries the sensor id.
+var defaultSensorTags = []string{"sensor_id", "device_id", "id", "host"}
+
+type InfluxHandler struct {
+	infoLog  *log.Logger
+	errorLog *log.Logger
+	ingestor *ingest.Ingestor
+}
+
+func NewInfluxHandler(infoLog *log.Logger, errorLog *log.Logger, ingestor *ingest.Ingestor) *InfluxHandler {
+	return &InfluxHandler{
+		infoLog:  infoLog,
+		errorLog: errorLog,
+		ingestor: ingestor,
+	}
+}
+
+type lineError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// influxErrorResponse follows the error shape of the InfluxDB v2 API and
+// adds per-line details for partially accepted batches. At most
+// maxBulkLineErrors lines are listed.
+type influxErrorResponse struct {
+	Code            string      `json:"code"`
+	Message         string      `json:"message"`
+	Accepted        int         `json:"accepted"`
+	Rejected        int         `json:"rejected"`
+	Errors          []lineError `json:"errors,omitempty"`
+	ErrorsTruncated bool        `json:"errors_truncated,omitempty"`
+}
+
+func (resp *influxErrorResponse) reject(line int, message string) {
+	resp.Rejected++
+	if len(resp.Errors) >= maxBulkLineErrors {
+		resp.ErrorsTruncated = true
+		return
+	}
+	resp.Errors = append(resp.Errors, lineError{Line: line, Message: message})
+}
+
+// Write accepts InfluxDB line protocol like POST /api/v2/write. The line
+// measurement becomes the measurement, each numeric field a parameter (a
+// field named "value" has no parameter) and the sensor id is read from the
+// tag named by ?sensor_tag= or the first of sensor_id, device_id, id, host.
+// Tags "sensor_name" and "unit" are used when present. Lines are stored
+// like bulk lines, so they bypass store_interval and SSE. Valid lines are
+// stored even when others fail; failures, including lines whose every
+// reading was quarantined, are reported per line with 400.
+func (h *InfluxHandler) Write(w http.ResponseWriter, r *http.Request) {
+	precision, err := influx.ParsePrecision(r.URL.Query().Get("precision"))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, influxErrorResponse{Code: "invalid", Message: err.Error()})
+		return
+	}
+	sensorTags := defaultSensorTags
+	if tag := r.URL.Query().Get("sensor_tag"); tag != "" {
+		sensorTags = []string{tag}
+	}
+
+	ctx := r.Context()
+	bulk := h.ingestor.NewBulk()
+	defer bulk.Rollback()
+
+	resp := influxErrorResponse{Code: "invalid"}
+	pending := 0
+	commit := func() error {
+		if err := bulk.Commit(ctx); err != nil {
+			return err
+		}
+		resp.Accepted += pending
+		pending = 0
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		line, err := parseLine(text, precision, sensorTags)
+		if err == nil {
+			err = bulk.Add(ctx, &line)
+		}
+		if err != nil {
+			resp.reject(lineNo, err.Error())
+			continue
+		}
+		pending++
+		if bulk.Len() >= maxBulkBatchSize {
+			if err := commit(); err != nil {
+				h.fail(w, err, resp)
+				return
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		resp.Message = err.Error()
+		h.writeError(w, bodyErrorStatus(err), resp)
+		return
+	}
+	if err := commit(); err != nil {
+		h.fail(w, err, resp)
+		return
+	}
+
+	if resp.Rejected > 0 {
+		resp.Message = fmt.Sprintf("partial write: %d of %d lines rejected", resp.Rejected, resp.Accepted+resp.Rejected)
+		h.infoLog.Println(resp.Message)
+		h.writeError(w, http.StatusBadRequest, resp)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseLine turns one line of line protocol into a bulk line.
+func parseLine(text string, precision time.Duration, sensorTags []string) (models.BulkMeasurementLine, error) {
+	var line models.BulkMeasurementLine
+	p, err := influx.ParseLine(text, precision)
+	if err != nil {
+		return line, err
+	}
+
+	for _, tag := range sensorTags {
+		if v, ok := p.Tags[tag]; ok {
+			line.SensorID = v
+			break
+		}
+	}
+	if len(line.SensorID) == 0 {
+		return line, fmt.Errorf("%w: no sensor tag (%s)", influx.ErrBadLine, strings.Join(sensorTags, ", "))
+	}
+	if len(p.Fields) == 0 {
+		return line, fmt.Errorf("%w: no numeric fields", influx.ErrBadLine)
+	}
+
+	line.SensorName = line.SensorID
+	line.Timestamp = p.Timestamp
+	if name, ok := p.Tags["sensor_name"]; ok {
+		line.SensorName = name
+	}
+	var unit *string
+	if u, ok := p.Tags["unit"]; ok {
+		unit = &u
+	}
+	for _, f := range p.Fields {
+		v := models.MeasurementValue{Measurement: p.Measurement, Value: f.Value, Unit: unit}
+		if f.Key != "value" {
+			parameter := f.Key
+			v.Parameter = &parameter
+		}
+		line.Measurements = append(line.Measurements, v)
+	}
+	return line, nil
+}
+
+// fail answers a failed commit. Lines counted as accepted were committed
+// by earlier batches; rejected lines are still listed.
+func (h *InfluxHandler) fail(w http.ResponseWriter, err error, resp influxErrorResponse) {
+	h.errorLog.Println(err)
+	status, code := http.StatusInternalServerError, "internal error"
+	if s, c := ingestErrorCode(err); s == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "1")
+		status, code = s, c
+	}
+	resp.Code = code
+	resp.Message = err.Error()
+	h.writeError(w, status, resp)
+}
+
+func (h *InfluxHandler) writeError(w http.ResponseWriter, status int, resp influxErrorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		h.errorLog.Println(err)
+	}
+}
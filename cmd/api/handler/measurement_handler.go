@@ -2,15 +2,17 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"sensor/cmd/api/codec"
+	"sensor/cmd/api/ingest"
 	"sensor/cmd/api/models"
 	"sensor/cmd/api/pagination"
-	"sensor/cmd/api/settings"
 	"sensor/cmd/api/storage"
-	"sync"
 	"time"
 
 	"strconv"
@@ -55,7 +57,12 @@ func (b *SSEBroker) addClient(sensorID string) *SSEClient {
 	return c
 }
 
-func (b *SSEBroker) listen() {
+// Publish hands freshly ingested measurements to the clients of sensorID.
+func (b *SSEBroker) Publish(sensorID string, measurements []models.MeasurementSSE) {
+	b.Notifier <- MeasurementEvent{sensorID: sensorID, measurements: measurements}
+}
+
+func (b *SSEBroker) Listen() {
 	for {
 		select {
 		case s := <-b.newClients:
@@ -93,131 +100,275 @@ func (b *SSEBroker) listen() {
 }
 
 type MeasurementHandler struct {
-	infoLog        *log.Logger
-	errorLog       *log.Logger
-	storage        *storage.SQLStorage
-	settings       *settings.SettingsCache
-	prevRecordTime time.Time
-	prevRecordMu   sync.Mutex
-	broker         *SSEBroker
+	infoLog  *log.Logger
+	errorLog *log.Logger
+	storage  *storage.SQLStorage
+	broker   *SSEBroker
+	ingestor *ingest.Ingestor
 }
 
-func NewMeasurementHandler(infoLog *log.Logger, errorLog *log.Logger, storage *storage.SQLStorage, settings *settings.SettingsCache) *MeasurementHandler {
-	prevRecordTime := time.Now().Add(-settings.GetStoreInterval())
-	broker := NewSSEBroker(infoLog, errorLog)
-	go broker.listen()
+func NewMeasurementHandler(infoLog *log.Logger, errorLog *log.Logger, storage *storage.SQLStorage, broker *SSEBroker, ingestor *ingest.Ingestor) *MeasurementHandler {
 	return &MeasurementHandler{
-		infoLog:        infoLog,
-		errorLog:       errorLog,
-		storage:        storage,
-		settings:       settings,
-		prevRecordTime: prevRecordTime,
-		broker:         broker,
+		infoLog:  infoLog,
+		errorLog: errorLog,
+		storage:  storage,
+		broker:   broker,
+		ingestor: ingestor,
 	}
 }
 
 const pathParamSensorID = "sensor_id"
 
+// Create ingests a live reading. With ?partial=true the valid readings of
+// a measurements array are stored even when others are rejected, and the
+// response reports each reading. A timestamp older than max_age or more
+// than a few minutes ahead of the server clock gets 400.
 func (h *MeasurementHandler) Create(w http.ResponseWriter, r *http.Request) {
 	sensorID := chi.URLParam(r, pathParamSensorID)
+	partial := r.URL.Query().Get("partial") == "true"
+
+	req, err := codec.DecodeCreateMeasurementReq(r.Header.Get("Content-Type"), r.Body)
+	if err != nil {
+		h.errorLog.Println(err)
+		ingestError(w, h.errorLog, err)
+		return
+	}
+
+	ingestFn := h.ingestor.Ingest
+	if partial {
+		ingestFn = h.ingestor.IngestPartial
+	}
+	result, err := ingestFn(r.Context(), sensorID, &req)
+	if err != nil {
+		h.errorLog.Println(err)
+		ingestError(w, h.errorLog, err)
+		return
+	}
+
+	if partial {
+		writePartialResult(w, h.errorLog, result)
+		return
+	}
+	writeIngestResult(w, h.errorLog, result)
+}
+
+// Backfill stores historical readings buffered by a device while it was
+// offline. Readings may arrive in any order, must carry a timestamp, bypass
+// the store_interval throttle and are not pushed to live SSE clients.
+func (h *MeasurementHandler) Backfill(w http.ResponseWriter, r *http.Request) {
+	sensorID := chi.URLParam(r, pathParamSensorID)
 
-	var req models.CreateMeasurementReq
+	var req models.BackfillMeasurementReq
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		h.errorLog.Println(err)
-		// improve error response here
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), bodyErrorStatus(err))
 		return
 	}
-	values, err := req.ExtractValues()
+
+	records, err := h.ingestor.Backfill(r.Context(), sensorID, &req)
 	if err != nil {
 		h.errorLog.Println(err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		ingestError(w, h.errorLog, err)
 		return
 	}
 
-	ctx := r.Context()
-	currTimestamp := time.Now().UTC()
-	storeInterval := h.settings.GetStoreInterval()
-	ts := req.Timestamp
-	if ts.IsZero() {
-		ts = currTimestamp
-	}
-	h.prevRecordMu.Lock()
-	timeSincePrevAdd := currTimestamp.Sub(h.prevRecordTime)
-	shouldStore := timeSincePrevAdd >= storeInterval
-	if shouldStore {
-		h.prevRecordTime = currTimestamp
-	}
-	h.prevRecordMu.Unlock()
-
-	sseResponse := make([]models.MeasurementSSE, 0, len(values))
-	httpResponse := make([]storage.MeasurementRecord, 0, len(values))
-	for _, v := range values {
-		var m models.MeasurementSSE
-		m.SensorID = &sensorID
-		m.SensorName = &req.SensorName
-		m.Measurement = v.Measurement
-		m.Parameter = v.Parameter
-		m.Value = v.Value
-		m.Unit = v.Unit
-		m.Timestamp = ts
-		sseResponse = append(sseResponse, m)
-
-		h.storage.UpsertSensor(ctx, &sensorID, &req.SensorName, ts)
-		h.storage.UpdateSensorMeasurement(ctx, sensorID, v.Measurement)
-
-		if shouldStore {
-			record, err := h.storage.CreateMeasurement(ctx, &sensorID, &req.SensorName, &v, currTimestamp)
-			if err != nil {
-				h.errorLog.Println(err)
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			httpResponse = append(httpResponse, record)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(records); err != nil {
+		h.errorLog.Println(err)
+	}
+}
+
+type quarantinedResponse struct {
+	Status      string                     `json:"status"`
+	Quarantined []storage.QuarantineRecord `json:"quarantined"`
+}
+
+// writeIngestResult answers a live ingestion: 201 with the stored records,
+// 200 with the earlier records when every reading was a duplicate, 422
+// when every reading was quarantined, or 202 when every reading was
+// aggregated or skipped by store_interval.
+func writeIngestResult(w http.ResponseWriter, errorLog *log.Logger, result ingest.Result) {
+	if len(result.Records) == 0 && result.Aggregated == 0 && len(result.Quarantined) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		if err := json.NewEncoder(w).Encode(quarantinedResponse{Status: "quarantined", Quarantined: result.Quarantined}); err != nil {
+			errorLog.Println(err)
 		}
+		return
 	}
-	h.broker.Notifier <- MeasurementEvent{sensorID: sensorID, measurements: sseResponse}
 
-	if !shouldStore {
-		// remaining := storeInterval - timeSincePrevAdd
-		// h.infoLog.Printf(
-		// 	"Skip storing. remaining=%s elapsed=%s interval=%s",
-		// 	remaining.Round(time.Second),
-		// 	timeSincePrevAdd.Round(time.Second),
-		// 	storeInterval.Round(time.Second),
-		// )
+	if len(result.Records) == 0 && result.Aggregated > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte(`{"status":"aggregated"}`))
+		return
+	}
+
+	if len(result.Records) == 0 {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusAccepted)
 		_, _ = w.Write([]byte(`{"status":"skipped","reason":"interval_not_reached"}`))
 		return
 	}
 
+	status := http.StatusCreated
+	if result.Duplicates == len(result.Records) {
+		status = http.StatusOK
+	}
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(w).Encode(httpResponse); err != nil {
-		h.errorLog.Println(err)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(result.Records); err != nil {
+		errorLog.Println(err)
+	}
+}
+
+type partialResponse struct {
+	Stored   int                 `json:"stored"`
+	Skipped  int                 `json:"skipped"`
+	Rejected int                 `json:"rejected"`
+	Items    []ingest.ItemResult `json:"items"`
+}
+
+// writePartialResult answers a partial ingestion with the outcome of each
+// reading: 200, or 422 when every reading was rejected.
+func writePartialResult(w http.ResponseWriter, errorLog *log.Logger, result ingest.Result) {
+	resp := partialResponse{Items: result.Items}
+	for _, item := range result.Items {
+		switch item.Status {
+		case ingest.ItemStored:
+			resp.Stored++
+		case ingest.ItemSkipped:
+			resp.Skipped++
+		case ingest.ItemRejected:
+			resp.Rejected++
+		}
+	}
+	status := http.StatusOK
+	if resp.Rejected == len(resp.Items) {
+		status = http.StatusUnprocessableEntity
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		errorLog.Println(err)
+	}
+}
+
+// errorResponse is the body of a failed ingestion. Step, SensorID and
+// Measurement locate the write that failed, if any.
+type errorResponse struct {
+	Code        string `json:"code"`
+	Message     string `json:"message"`
+	Step        string `json:"step,omitempty"`
+	SensorID    string `json:"sensor_id,omitempty"`
+	Measurement string `json:"measurement,omitempty"`
+}
+
+// ingestError answers a failed ingestion with ingestErrorStatus and an
+// errorResponse. A busy writer also sets Retry-After so that clients back
+// off.
+func ingestError(w http.ResponseWriter, errorLog *log.Logger, err error) {
+	status, code := ingestErrorCode(err)
+	resp := errorResponse{Code: code, Message: err.Error()}
+	var storeErr *ingest.StoreError
+	if errors.As(err, &storeErr) {
+		resp.Step = storeErr.Step
+		resp.SensorID = storeErr.SensorID
+		resp.Measurement = storeErr.Measurement
+	}
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "1")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		errorLog.Println(err)
 	}
 }
 
+// ingestErrorStatus maps oversized bodies to 413, payload problems to 400,
+// unknown content types to 415, a busy or slow writer to 503 and
+// everything else to 500.
+func ingestErrorStatus(err error) int {
+	status, _ := ingestErrorCode(err)
+	return status
+}
+
+// ingestErrorCode returns the status of ingestErrorStatus together with
+// the code reported in an errorResponse.
+func ingestErrorCode(err error) (int, string) {
+	var maxErr *http.MaxBytesError
+	var storeErr *ingest.StoreError
+	switch {
+	case errors.As(err, &maxErr):
+		return http.StatusRequestEntityTooLarge, "too_large"
+	case errors.Is(err, models.ErrBadPayload):
+		return http.StatusBadRequest, "invalid"
+	case errors.Is(err, codec.ErrUnsupportedMediaType):
+		return http.StatusUnsupportedMediaType, "unsupported_media_type"
+	case errors.Is(err, storage.ErrWriterBusy), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable, "unavailable"
+	case errors.As(err, &storeErr):
+		return http.StatusInternalServerError, "storage_error"
+	}
+	return http.StatusInternalServerError, "internal_error"
+}
+
+// bodyErrorStatus maps a failure to read or decode the request body to 413
+// when the body limit was hit and to 400 otherwise.
+func bodyErrorStatus(err error) int {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
 type pageResponse struct {
 	Items      []storage.MeasurementRecord `json:"items"`
 	NextCursor string                      `json:"next_cursor,omitempty"`
 	HasMore    bool                        `json:"has_more"`
 }
 
+// Get pages through the records of a sensor, newest first. With ?from= and
+// ?to= (RFC 3339, either optional) only readings taken in [from, to) are
+// returned, ordered by their timestamp; the cursor of such a page only
+// continues the same range.
 func (h *MeasurementHandler) Get(w http.ResponseWriter, r *http.Request) {
 	sensorID := chi.URLParam(r, pathParamSensorID)
+	q := r.URL.Query()
 	limit := 50
-	if s := r.URL.Query().Get("limit"); s != "" {
+	if s := q.Get("limit"); s != "" {
 		if n, err := strconv.Atoi(s); err == nil && n > 0 && n <= 200 {
 			limit = n
 		}
 	}
 
+	var within storage.TimeRange
+	for name, dst := range map[string]*time.Time{"from": &within.From, "to": &within.To} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, name+" must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		*dst = t
+	}
+	if !within.From.IsZero() && !within.To.IsZero() && !within.From.Before(within.To) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	ranged := !within.IsZero()
+
 	var cur *pagination.MeasurementCursor
-	if tok := r.URL.Query().Get("cursor"); tok != "" {
+	if tok := q.Get("cursor"); tok != "" {
 		c, err := pagination.Decode(tok)
-		if err != nil {
+		if err != nil || (c.Timestamp != nil) != ranged {
 			h.infoLog.Println("bad cursor")
 			http.Error(w, "bad cursor", http.StatusBadRequest)
 			return
@@ -225,7 +376,7 @@ func (h *MeasurementHandler) Get(w http.ResponseWriter, r *http.Request) {
 		cur = &c
 	}
 
-	items, err := h.storage.GetMeasurementsPage(sensorID, limit, cur)
+	items, err := h.storage.GetMeasurementsPage(sensorID, limit, cur, within)
 	if err != nil {
 		h.infoLog.Println("Failed to get measurements page")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -241,10 +392,14 @@ func (h *MeasurementHandler) Get(w http.ResponseWriter, r *http.Request) {
 	nextCursor := ""
 	if hasMore && len(items) > 0 {
 		last := items[len(items)-1]
-		nextCursor = pagination.Encode(pagination.MeasurementCursor{
+		next := pagination.MeasurementCursor{
 			CreatedAt: last.CreatedAt,
 			ID:        last.ID,
-		})
+		}
+		if ranged {
+			next.Timestamp = &last.Timestamp
+		}
+		nextCursor = pagination.Encode(next)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,266 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sensor/cmd/api/ingest"
+	"sensor/cmd/api/models"
+	"sensor/cmd/api/remotewrite"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxRemoteWriteBody    = 32 << 20
+	maxRemoteWriteDecoded = 256 << 20
+	maxRemoteWriteErrors  = 100
+)
+
+// defaultSensorLabels are tried in order when the request does not name
+// the label that carries the sensor id.
+var defaultSensorLabels = []string{"sensor_id", "device_id", "id", "instance"}
+
+type PrometheusHandler struct {
+	infoLog  *log.Logger
+	errorLog *log.Logger
+	ingestor *ingest.Ingestor
+}
+
+func NewPrometheusHandler(infoLog *log.Logger, errorLog *log.Logger, ingestor *ingest.Ingestor) *PrometheusHandler {
+	return &PrometheusHandler{
+		infoLog:  infoLog,
+		errorLog: errorLog,
+		ingestor: ingestor,
+	}
+}
+
+type seriesError struct {
+	Series  string `json:"series"`
+	Message string `json:"message"`
+}
+
+// remoteWriteResponse counts samples. It is only sent when samples were
+// rejected or the write failed; success is a bare 204.
+type remoteWriteResponse struct {
+	Accepted        int           `json:"accepted"`
+	Rejected        int           `json:"rejected"`
+	Duplicates      int           `json:"duplicates"`
+	Quarantined     int           `json:"quarantined"`
+	Errors          []seriesError `json:"errors,omitempty"`
+	ErrorsTruncated bool          `json:"errors_truncated,omitempty"`
+	Error           string        `json:"error,omitempty"`
+}
+
+func (resp *remoteWriteResponse) reject(series, message string, samples int) {
+	resp.Rejected += samples
+	if len(resp.Errors) >= maxRemoteWriteErrors {
+		resp.ErrorsTruncated = true
+		return
+	}
+	resp.Errors = append(resp.Errors, seriesError{Series: series, Message: message})
+}
+
+// seriesMapping names the labels that become the fields of a reading.
+type seriesMapping struct {
+	sensorLabels     []string
+	measurementLabel string
+	parameterLabel   string
+	prefix           string
+}
+
+// Write accepts a Prometheus remote_write 1.0 request: a snappy compressed
+// protobuf WriteRequest. Every sample is stored like a bulk line, so it
+// bypasses store_interval and SSE. The measurement is the metric name
+// without ?prefix= (or the label named by ?measurement_label=), the
+// parameter comes from the "parameter" label (?parameter_label=) and the
+// sensor id from the label named by ?sensor_label= or the first of
+// sensor_id, device_id, id, instance. Labels "sensor_name" and "unit" are
+// used when present. A hash of the series' labels and the sample's
+// timestamp make its message_id, so samples resent by Prometheus are
+// stored once. NaN and infinite samples other than staleness markers are
+// rejected.
+func (h *PrometheusHandler) Write(w http.ResponseWriter, r *http.Request) {
+	if strings.Contains(r.Header.Get("Content-Type"), "io.prometheus.write.v2") {
+		http.Error(w, "remote write 2.0 is not supported, use protobuf_message: prometheus.WriteRequest", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRemoteWriteBody))
+	if err != nil {
+		h.errorLog.Println(err)
+		http.Error(w, err.Error(), bodyErrorStatus(err))
+		return
+	}
+	series, err := remotewrite.Decode(body, maxRemoteWriteDecoded)
+	if err != nil {
+		h.errorLog.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	mapping := seriesMapping{
+		sensorLabels:     defaultSensorLabels,
+		measurementLabel: q.Get("measurement_label"),
+		parameterLabel:   "parameter",
+		prefix:           q.Get("prefix"),
+	}
+	if label := q.Get("sensor_label"); label != "" {
+		mapping.sensorLabels = []string{label}
+	}
+	if label := q.Get("parameter_label"); label != "" {
+		mapping.parameterLabel = label
+	}
+
+	ctx := r.Context()
+	bulk := h.ingestor.NewBulk()
+	defer bulk.Rollback()
+
+	var resp remoteWriteResponse
+	pending := 0
+	commit := func() error {
+		if bulk.Len() == 0 {
+			return nil
+		}
+		if err := bulk.Commit(ctx); err != nil {
+			return err
+		}
+		resp.Accepted += pending
+		resp.Duplicates = bulk.Duplicates()
+		resp.Quarantined = bulk.Quarantined()
+		pending = 0
+		return nil
+	}
+
+	for _, s := range series {
+		name := seriesName(s.Labels)
+		line, err := mapping.line(s.Labels)
+		if err != nil {
+			resp.reject(name, err.Error(), len(s.Samples))
+			continue
+		}
+		// Series that map to the same reading but differ in other labels
+		// must not pass for retries of each other.
+		prefix := "prometheus:" + seriesHash(name) + ":"
+		for _, sample := range s.Samples {
+			if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+				resp.reject(name, fmt.Sprintf("value %v is not a finite number", sample.Value), 1)
+				continue
+			}
+			l := line
+			l.Value = sample.Value
+			l.Timestamp = sample.Timestamp
+			messageID := prefix + strconv.FormatInt(sample.Timestamp.UnixMilli(), 10)
+			l.MessageID = &messageID
+			if err := bulk.Add(ctx, &l); err != nil {
+				resp.reject(name, err.Error(), 1)
+				continue
+			}
+			pending++
+			if bulk.Len() >= maxBulkBatchSize {
+				if err := commit(); err != nil {
+					h.fail(w, err, resp)
+					return
+				}
+			}
+		}
+	}
+	if err := commit(); err != nil {
+		h.fail(w, err, resp)
+		return
+	}
+
+	if resp.Rejected > 0 {
+		h.infoLog.Printf("Remote write accepted %d rejected %d samples", resp.Accepted, resp.Rejected)
+		h.writeResponse(w, http.StatusBadRequest, resp)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// line builds the reading of a series, without its sample.
+func (m seriesMapping) line(labels map[string]string) (models.BulkMeasurementLine, error) {
+	var line models.BulkMeasurementLine
+	for _, label := range m.sensorLabels {
+		if v, ok := labels[label]; ok && v != "" {
+			line.SensorID = v
+			break
+		}
+	}
+	if line.SensorID == "" {
+		return line, fmt.Errorf("%w: no sensor label (%s)", models.ErrBadPayload, strings.Join(m.sensorLabels, ", "))
+	}
+
+	var measurement string
+	if m.measurementLabel != "" {
+		measurement = labels[m.measurementLabel]
+	} else {
+		measurement = strings.TrimPrefix(labels["__name__"], m.prefix)
+	}
+	if measurement == "" {
+		return line, fmt.Errorf("%w: no measurement", models.ErrBadPayload)
+	}
+	line.Measurement = &measurement
+
+	line.SensorName = line.SensorID
+	if name, ok := labels["sensor_name"]; ok {
+		line.SensorName = name
+	}
+	if parameter, ok := labels[m.parameterLabel]; ok && parameter != "" {
+		line.Parameter = &parameter
+	}
+	if unit, ok := labels["unit"]; ok && unit != "" {
+		line.Unit = &unit
+	}
+	return line, nil
+}
+
+// seriesName formats labels the way Prometheus prints a series.
+func seriesName(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		if name != "__name__" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for n, name := range names {
+		pairs[n] = fmt.Sprintf("%s=%q", name, labels[name])
+	}
+	return labels["__name__"] + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// seriesHash returns a stable hash of a series name from seriesName,
+// which lists every label sorted by name.
+func seriesHash(name string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// fail answers a failed commit. Prometheus retries 5xx responses, and
+// samples it resends are not stored twice.
+func (h *PrometheusHandler) fail(w http.ResponseWriter, err error, resp remoteWriteResponse) {
+	h.errorLog.Println(err)
+	status := ingestErrorStatus(err)
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "1")
+	}
+	resp.Error = err.Error()
+	h.writeResponse(w, status, resp)
+}
+
+func (h *PrometheusHandler) writeResponse(w http.ResponseWriter, status int, resp remoteWriteResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		h.errorLog.Println(err)
+	}
+}
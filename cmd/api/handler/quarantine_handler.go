@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sensor/cmd/api/ingest"
+	"sensor/cmd/api/storage"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type QuarantineHandler struct {
+	infoLog  *log.Logger
+	errorLog *log.Logger
+	storage  *storage.SQLStorage
+	ingestor *ingest.Ingestor
+}
+
+func NewQuarantineHandler(infoLog *log.Logger, errorLog *log.Logger, storage *storage.SQLStorage, ingestor *ingest.Ingestor) *QuarantineHandler {
+	return &QuarantineHandler{
+		infoLog:  infoLog,
+		errorLog: errorLog,
+		storage:  storage,
+		ingestor: ingestor,
+	}
+}
+
+type quarantinePageResponse struct {
+	Items        []storage.QuarantineRecord `json:"items"`
+	NextBeforeID *int64                     `json:"next_before_id,omitempty"`
+}
+
+type purgeResponse struct {
+	Deleted int64 `json:"deleted"`
+}
+
+// List returns quarantined readings newest first, optionally filtered by
+// ?sensor_id=. Pass next_before_id as ?before_id= to get the next page.
+func (h *QuarantineHandler) List(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := 50
+	if v := q.Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			limit = n
+		}
+	}
+	var beforeID int64
+	if v := q.Get("before_id"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "bad before_id", http.StatusBadRequest)
+			return
+		}
+		beforeID = n
+	}
+
+	items, err := h.storage.GetQuarantinePage(r.Context(), q.Get("sensor_id"), limit, beforeID)
+	if err != nil {
+		h.errorLog.Printf("Failed to list quarantine %v", err)
+		http.Error(w, "Internal Server error", http.StatusInternalServerError)
+		return
+	}
+	resp := quarantinePageResponse{Items: items}
+	if len(items) > 0 {
+		last := items[len(items)-1].ID
+		resp.NextBeforeID = &last
+	}
+	h.writeJSON(w, http.StatusOK, resp)
+}
+
+// Release stores a quarantined reading as a measurement flagged with the
+// reason it was quarantined for.
+func (h *QuarantineHandler) Release(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+
+	record, err := h.ingestor.ReleaseQuarantine(r.Context(), id)
+	switch {
+	case errors.Is(err, storage.ErrQuarantineNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, storage.ErrNotReleasable):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case ingestErrorStatus(err) == http.StatusServiceUnavailable:
+		h.errorLog.Printf("Failed to release quarantined reading %d %v", id, err)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		h.errorLog.Printf("Failed to release quarantined reading %d %v", id, err)
+		http.Error(w, "Internal Server error", http.StatusInternalServerError)
+		return
+	}
+	h.infoLog.Printf("Released quarantined reading %d as measurement %d", id, record.ID)
+	h.writeJSON(w, http.StatusCreated, record)
+}
+
+func (h *QuarantineHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	n, err := h.ingestor.PurgeQuarantine(r.Context(), id, "", time.Time{})
+	if err != nil {
+		h.errorLog.Printf("Failed to delete quarantined reading %d %v", id, err)
+		http.Error(w, "Internal Server error", http.StatusInternalServerError)
+		return
+	}
+	if n == 0 {
+		http.Error(w, storage.ErrQuarantineNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Purge deletes quarantined readings, optionally only those of ?sensor_id=
+// or received before ?before= (RFC 3339).
+func (h *QuarantineHandler) Purge(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var before time.Time
+	if v := q.Get("before"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "before must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		before = t
+	}
+
+	n, err := h.ingestor.PurgeQuarantine(r.Context(), 0, q.Get("sensor_id"), before)
+	if err != nil {
+		h.errorLog.Printf("Failed to purge quarantine %v", err)
+		http.Error(w, "Internal Server error", http.StatusInternalServerError)
+		return
+	}
+	h.infoLog.Printf("Purged %d quarantined readings", n)
+	h.writeJSON(w, http.StatusOK, purgeResponse{Deleted: n})
+}
+
+func (h *QuarantineHandler) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.errorLog.Println(err)
+	}
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sensor/cmd/api/ingest"
+	"sensor/cmd/api/models"
+	"strings"
+)
+
+type SensorCommunityHandler struct {
+	infoLog  *log.Logger
+	errorLog *log.Logger
+	ingestor *ingest.Ingestor
+}
+
+func NewSensorCommunityHandler(infoLog *log.Logger, errorLog *log.Logger, ingestor *ingest.Ingestor) *SensorCommunityHandler {
+	return &SensorCommunityHandler{
+		infoLog:  infoLog,
+		errorLog: errorLog,
+		ingestor: ingestor,
+	}
+}
+
+// Push accepts the unchanged payload of airrohr firmware configured to send
+// to a custom API. The sensor id is taken from the X-Sensor header, e.g.
+// esp8266-1234567, falling back to esp8266id in the body.
+func (h *SensorCommunityHandler) Push(w http.ResponseWriter, r *http.Request) {
+	var req models.SensorCommunityReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.errorLog.Println(err)
+		http.Error(w, err.Error(), bodyErrorStatus(err))
+		return
+	}
+
+	sensorID := strings.TrimSpace(r.Header.Get("X-Sensor"))
+	if len(sensorID) == 0 {
+		sensorID = req.SensorID()
+	}
+	if len(sensorID) == 0 {
+		err := fmt.Errorf("%w: X-Sensor header or esp8266id is required", models.ErrBadPayload)
+		h.errorLog.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	createReq, ignored := req.ToCreateMeasurementReq(sensorID)
+	if len(ignored) != 0 {
+		h.infoLog.Printf("Sensor.Community push from %s ignored value types %s", sensorID, strings.Join(ignored, ", "))
+	}
+	if len(createReq.Measurements) == 0 {
+		err := fmt.Errorf("%w: no supported sensordatavalues", models.ErrBadPayload)
+		h.errorLog.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.ingestor.Ingest(r.Context(), sensorID, &createReq)
+	if err != nil {
+		h.errorLog.Println(err)
+		ingestError(w, h.errorLog, err)
+		return
+	}
+
+	writeIngestResult(w, h.errorLog, result)
+}
@@ -115,6 +115,12 @@ func (h *SettingsHandler) UpdateSetting(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if err := settings.Validate(key, body.Value); err != nil {
+		h.errorLog.Printf("Invalid value '%s' for key '%s' %v", body.Value, key, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	item, err := h.storage.UpsertSetting(r.Context(), key, body.Value)
 	if err != nil {
 		h.errorLog.Printf("Failed to update settings for key '%s' %v", key, err)
@@ -149,6 +155,18 @@ func (h *SettingsHandler) UpdateSetting(w http.ResponseWriter, r *http.Request)
 		h.settings.SetMaxAge(duration)
 		h.infoLog.Printf("Apply new max age value %s", item.Value)
 
+	case settings.SettingKeyThrottleScope:
+		h.settings.SetThrottleScope(item.Value)
+		h.infoLog.Printf("Apply new throttle scope %s", item.Value)
+
+	case settings.SettingKeyStoreMode:
+		h.settings.SetStoreMode(item.Value)
+		h.infoLog.Printf("Apply new store mode %s", item.Value)
+
+	case settings.SettingKeyDedupMode:
+		h.settings.SetDedupMode(item.Value)
+		h.infoLog.Printf("Apply new dedup mode %s", item.Value)
+
 	}
 
 	resp := SettingResponseValue{Key: key, Value: item.Value, UpdatedAt: item.UpdatedAt}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sensor/cmd/api/throttle"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type ThrottleHandler struct {
+	infoLog  *log.Logger
+	errorLog *log.Logger
+	throttle *throttle.StoreThrottle
+}
+
+func NewThrottleHandler(infoLog *log.Logger, errorLog *log.Logger, throttle *throttle.StoreThrottle) *ThrottleHandler {
+	return &ThrottleHandler{
+		infoLog:  infoLog,
+		errorLog: errorLog,
+		throttle: throttle,
+	}
+}
+
+type throttleResponse struct {
+	Items []throttle.State `json:"items"`
+}
+
+// Get lists when each sensor (or sensor measurement, depending on
+// throttle_scope) last had a reading stored and when the next one is accepted.
+func (h *ThrottleHandler) Get(w http.ResponseWriter, r *http.Request) {
+	sensorID := chi.URLParam(r, pathParamSensorID)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(throttleResponse{Items: h.throttle.Snapshot(sensorID)}); err != nil {
+		h.errorLog.Println(err)
+	}
+}
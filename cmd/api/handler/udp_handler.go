@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sensor/cmd/api/udp"
+)
+
+type UDPHandler struct {
+	infoLog  *log.Logger
+	errorLog *log.Logger
+	tracker  *udp.SequenceTracker
+}
+
+func NewUDPHandler(infoLog *log.Logger, errorLog *log.Logger, tracker *udp.SequenceTracker) *UDPHandler {
+	return &UDPHandler{
+		infoLog:  infoLog,
+		errorLog: errorLog,
+		tracker:  tracker,
+	}
+}
+
+type udpStatsResponse struct {
+	Items []udp.SensorStats `json:"items"`
+}
+
+// Stats lists per-sensor datagram counters, including datagrams detected
+// as lost from gaps in sequence numbers.
+func (h *UDPHandler) Stats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(udpStatsResponse{Items: h.tracker.Snapshot()}); err != nil {
+		h.errorLog.Println(err)
+	}
+}
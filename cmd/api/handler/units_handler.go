@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sensor/cmd/api/storage"
+	"sensor/cmd/api/units"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type UnitsHandler struct {
+	infoLog  *log.Logger
+	errorLog *log.Logger
+	storage  *storage.SQLStorage
+	registry *units.Registry
+}
+
+func NewUnitsHandler(infoLog *log.Logger, errorLog *log.Logger, storage *storage.SQLStorage, registry *units.Registry) *UnitsHandler {
+	return &UnitsHandler{
+		infoLog:  infoLog,
+		errorLog: errorLog,
+		storage:  storage,
+		registry: registry,
+	}
+}
+
+type unitsResponse struct {
+	Items []units.Canonical `json:"items"`
+	Known []string          `json:"known_units"`
+}
+
+type canonicalUnitInput struct {
+	Unit string `json:"unit"`
+}
+
+// List returns the canonical unit of every registered measurement and the
+// units that readings can be converted from.
+func (h *UnitsHandler) List(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, http.StatusOK, unitsResponse{Items: h.registry.List(), Known: units.Known()})
+}
+
+func (h *UnitsHandler) Get(w http.ResponseWriter, r *http.Request) {
+	c, ok := h.registry.Get(chi.URLParam(r, pathParamMeasurement))
+	if !ok {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, c)
+}
+
+// Update sets the canonical unit of the measurement in the path. Readings
+// stored before keep their unit, so it should only change on an empty or
+// freshly named measurement.
+func (h *UnitsHandler) Update(w http.ResponseWriter, r *http.Request) {
+	var body canonicalUnitInput
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		h.errorLog.Println("Invalid JSON")
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	c := units.Canonical{Measurement: chi.URLParam(r, pathParamMeasurement), Unit: body.Unit}
+	if err := c.Validate(); err != nil {
+		h.errorLog.Printf("Invalid canonical unit for '%s' %v", c.Measurement, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stored, err := h.storage.UpsertCanonicalUnit(r.Context(), c)
+	if err != nil {
+		http.Error(w, "Internal Server error", http.StatusInternalServerError)
+		return
+	}
+	h.registry.Set(stored)
+	h.infoLog.Printf("Apply canonical unit %s for %s", stored.Unit, stored.Measurement)
+	h.writeJSON(w, http.StatusOK, stored)
+}
+
+func (h *UnitsHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	measurement := chi.URLParam(r, pathParamMeasurement)
+	found, err := h.storage.DeleteCanonicalUnit(r.Context(), measurement)
+	if err != nil {
+		h.errorLog.Printf("Failed to delete canonical unit '%s' %v", measurement, err)
+		http.Error(w, "Internal Server error", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	h.registry.Delete(measurement)
+	h.infoLog.Printf("Removed canonical unit for %s", measurement)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *UnitsHandler) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.errorLog.Println(err)
+	}
+}
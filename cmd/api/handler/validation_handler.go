@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sensor/cmd/api/storage"
+	"sensor/cmd/api/validation"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const pathParamMeasurement = "measurement"
+
+type ValidationHandler struct {
+	infoLog  *log.Logger
+	errorLog *log.Logger
+	storage  *storage.SQLStorage
+	rules    *validation.Rules
+}
+
+func NewValidationHandler(infoLog *log.Logger, errorLog *log.Logger, storage *storage.SQLStorage, rules *validation.Rules) *ValidationHandler {
+	return &ValidationHandler{
+		infoLog:  infoLog,
+		errorLog: errorLog,
+		storage:  storage,
+		rules:    rules,
+	}
+}
+
+type validationRulesResponse struct {
+	Items []validation.Rule `json:"items"`
+}
+
+func (h *ValidationHandler) ListRules(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, http.StatusOK, validationRulesResponse{Items: h.rules.List()})
+}
+
+func (h *ValidationHandler) GetRule(w http.ResponseWriter, r *http.Request) {
+	measurement := chi.URLParam(r, pathParamMeasurement)
+	rule, ok := h.rules.Get(measurement)
+	if !ok {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, rule)
+}
+
+// UpdateRule creates or replaces the rule of the measurement in the path,
+// e.g. {"min":0,"max":1000,"units":["µg/m3"],"sentinels":[65535],"action":"reject"}.
+func (h *ValidationHandler) UpdateRule(w http.ResponseWriter, r *http.Request) {
+	var rule validation.Rule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		h.errorLog.Println("Invalid JSON")
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	rule.Measurement = chi.URLParam(r, pathParamMeasurement)
+	if err := rule.Validate(); err != nil {
+		h.errorLog.Printf("Invalid validation rule for '%s' %v", rule.Measurement, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stored, err := h.storage.UpsertValidationRule(r.Context(), rule)
+	if err != nil {
+		http.Error(w, "Internal Server error", http.StatusInternalServerError)
+		return
+	}
+	h.rules.Set(stored)
+	h.infoLog.Printf("Apply validation rule for %s action %s", stored.Measurement, stored.Action)
+	h.writeJSON(w, http.StatusOK, stored)
+}
+
+func (h *ValidationHandler) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	measurement := chi.URLParam(r, pathParamMeasurement)
+	found, err := h.storage.DeleteValidationRule(r.Context(), measurement)
+	if err != nil {
+		h.errorLog.Printf("Failed to delete validation rule '%s' %v", measurement, err)
+		http.Error(w, "Internal Server error", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	h.rules.Delete(measurement)
+	h.infoLog.Printf("Removed validation rule for %s", measurement)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *ValidationHandler) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.errorLog.Println(err)
+	}
+}
@@ -0,0 +1,313 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sensor/cmd/api/ingest"
+	"sensor/cmd/api/models"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsAuthTimeout  = 10 * time.Second
+	wsPingInterval = 30 * time.Second
+	wsPongWait     = 2 * wsPingInterval
+	wsWriteWait    = 10 * time.Second
+	wsMaxFrameSize = 1 << 20
+)
+
+// Frame types of the WebSocket protocol.
+const (
+	wsTypeAuth    = "auth"
+	wsTypeAuthOK  = "auth_ok"
+	wsTypeReading = "reading"
+	wsTypeAck     = "ack"
+	wsTypeError   = "error"
+)
+
+// wsAuthFrame is the first frame a device sends.
+type wsAuthFrame struct {
+	Type       string `json:"type"`
+	SensorID   string `json:"sensor_id"`
+	SensorName string `json:"sensor_name"`
+	Token      string `json:"token"`
+}
+
+// wsReadingFrame is a create request with a frame id, which is echoed in
+// the acknowledgement so devices can match replies to frames.
+type wsReadingFrame struct {
+	Type string          `json:"type"`
+	ID   json.RawMessage `json:"id,omitempty"`
+	models.CreateMeasurementReq
+}
+
+// wsReply acknowledges a frame. Status is stored, duplicate, aggregated,
+// skipped or quarantined; errors carry Code and Message instead.
+type wsReply struct {
+	Type        string          `json:"type"`
+	ID          json.RawMessage `json:"id,omitempty"`
+	SensorID    string          `json:"sensor_id,omitempty"`
+	Status      string          `json:"status,omitempty"`
+	RecordIDs   []int64         `json:"record_ids,omitempty"`
+	Quarantined []string        `json:"quarantined,omitempty"`
+	Code        string          `json:"code,omitempty"`
+	Message     string          `json:"message,omitempty"`
+	Step        string          `json:"step,omitempty"`
+}
+
+// WebSocketHandler lets always-connected devices stream readings over one
+// WebSocket. A device authenticates with its first frame and every reading
+// frame after that is ingested like POST /api/measurements/{sensor_id} and
+// answered with an ack or error frame, in order. While the connection is
+// open the sensor's last_seen is kept current.
+type WebSocketHandler struct {
+	infoLog  *log.Logger
+	errorLog *log.Logger
+	ingestor *ingest.Ingestor
+	// tokens maps sensor ids to their token. When nil any sensor may
+	// connect without a token.
+	tokens   map[string]string
+	upgrader websocket.Upgrader
+
+	mu    sync.Mutex
+	conns map[*websocket.Conn]struct{}
+}
+
+func NewWebSocketHandler(infoLog *log.Logger, errorLog *log.Logger, ingestor *ingest.Ingestor, tokens map[string]string) *WebSocketHandler {
+	return &WebSocketHandler{
+		infoLog:  infoLog,
+		errorLog: errorLog,
+		ingestor: ingestor,
+		tokens:   tokens,
+		upgrader: websocket.Upgrader{
+			// Devices authenticate with a token rather than cookies, so
+			// cross-origin connections are harmless.
+			CheckOrigin: func(*http.Request) bool { return true },
+		},
+		conns: make(map[*websocket.Conn]struct{}),
+	}
+}
+
+// LoadDeviceTokens reads WebSocket device credentials, one
+// "sensor_id:token" per line. Empty lines and lines starting with '#' are
+// ignored.
+func LoadDeviceTokens(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open websocket credentials: %w", err)
+	}
+	defer f.Close()
+
+	tokens := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sensorID, token, ok := strings.Cut(line, ":")
+		if !ok || sensorID == "" || token == "" {
+			return nil, fmt.Errorf("websocket credentials line %d: expected sensor_id:token", lineNo)
+		}
+		tokens[sensorID] = token
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read websocket credentials: %w", err)
+	}
+	return tokens, nil
+}
+
+// Close closes every open connection. It is meant for server shutdown,
+// since hijacked connections are not closed by http.Server.Shutdown.
+func (h *WebSocketHandler) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for conn := range h.conns {
+		msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+		_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		_ = conn.Close()
+	}
+}
+
+func (h *WebSocketHandler) Serve(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already answered the request.
+		h.errorLog.Println(err)
+		return
+	}
+	h.mu.Lock()
+	h.conns[conn] = struct{}{}
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		delete(h.conns, conn)
+		h.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	conn.SetReadLimit(wsMaxFrameSize)
+	auth, err := h.authenticate(conn)
+	if err != nil {
+		h.infoLog.Printf("WebSocket from %s refused: %v", r.RemoteAddr, err)
+		h.closeWith(conn, websocket.ClosePolicyViolation, err.Error())
+		return
+	}
+	sensorID, sensorName := auth.SensorID, auth.SensorName
+	if sensorName == "" {
+		sensorName = sensorID
+	}
+	h.infoLog.Printf("WebSocket sensor %s connected from %s", sensorID, r.RemoteAddr)
+	h.touch(sensorID, sensorName)
+	if err := h.reply(conn, wsReply{Type: wsTypeAuthOK, SensorID: sensorID}); err != nil {
+		return
+	}
+
+	// Pongs answer our pings and keep the connection, and the sensor's
+	// last_seen, alive.
+	_ = conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		h.touch(sensorID, sensorName)
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	done := make(chan struct{})
+	defer close(done)
+	go h.ping(conn, done)
+
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				h.infoLog.Printf("WebSocket sensor %s read failed %v", sensorID, err)
+			}
+			break
+		}
+		if messageType != websocket.TextMessage {
+			if err := h.reply(conn, wsReply{Type: wsTypeError, Code: "invalid", Message: "only text frames are supported"}); err != nil {
+				break
+			}
+			continue
+		}
+		if err := h.reply(conn, h.ingest(r.Context(), sensorID, sensorName, data)); err != nil {
+			break
+		}
+	}
+
+	h.touch(sensorID, sensorName)
+	h.infoLog.Printf("WebSocket sensor %s disconnected", sensorID)
+}
+
+// authenticate reads the auth frame and checks its token.
+func (h *WebSocketHandler) authenticate(conn *websocket.Conn) (wsAuthFrame, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(wsAuthTimeout))
+	var auth wsAuthFrame
+	if err := conn.ReadJSON(&auth); err != nil {
+		return auth, fmt.Errorf("read auth frame: %w", err)
+	}
+	if auth.Type != wsTypeAuth || auth.SensorID == "" {
+		return auth, errors.New(`first frame must be {"type":"auth","sensor_id":...}`)
+	}
+	if h.tokens != nil {
+		token, ok := h.tokens[auth.SensorID]
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(auth.Token)) != 1 {
+			return auth, errors.New("invalid sensor_id or token")
+		}
+	}
+	return auth, nil
+}
+
+// ingest stores one reading frame and builds its reply.
+func (h *WebSocketHandler) ingest(ctx context.Context, sensorID, sensorName string, data []byte) wsReply {
+	var frame wsReadingFrame
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return wsReply{Type: wsTypeError, Code: "invalid", Message: err.Error()}
+	}
+	reply := wsReply{Type: wsTypeAck, ID: frame.ID}
+	if frame.Type != "" && frame.Type != wsTypeReading {
+		reply.Type, reply.Code, reply.Message = wsTypeError, "invalid", fmt.Sprintf("unknown frame type %q", frame.Type)
+		return reply
+	}
+	if frame.SensorName == "" {
+		frame.SensorName = sensorName
+	}
+
+	result, err := h.ingestor.Ingest(ctx, sensorID, &frame.CreateMeasurementReq)
+	if err != nil {
+		h.errorLog.Printf("WebSocket sensor %s frame rejected %v", sensorID, err)
+		_, reply.Code = ingestErrorCode(err)
+		reply.Type, reply.Message = wsTypeError, err.Error()
+		var storeErr *ingest.StoreError
+		if errors.As(err, &storeErr) {
+			reply.Step = storeErr.Step
+		}
+		return reply
+	}
+
+	for _, q := range result.Quarantined {
+		reply.Quarantined = append(reply.Quarantined, q.Reason)
+	}
+	for _, record := range result.Records {
+		reply.RecordIDs = append(reply.RecordIDs, record.ID)
+	}
+	switch {
+	case len(result.Records) == 0 && result.Aggregated == 0 && len(result.Quarantined) > 0:
+		reply.Status = "quarantined"
+	case len(result.Records) == 0 && result.Aggregated > 0:
+		reply.Status = "aggregated"
+	case len(result.Records) == 0:
+		reply.Status = "skipped"
+	case result.Duplicates == len(result.Records):
+		reply.Status = "duplicate"
+	default:
+		reply.Status = "stored"
+	}
+	return reply
+}
+
+func (h *WebSocketHandler) reply(conn *websocket.Conn, reply wsReply) error {
+	_ = conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	if err := conn.WriteJSON(reply); err != nil {
+		h.errorLog.Printf("WebSocket write failed %v", err)
+		return err
+	}
+	return nil
+}
+
+func (h *WebSocketHandler) ping(conn *websocket.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// touch records the connection in the sensor's last_seen.
+func (h *WebSocketHandler) touch(sensorID, sensorName string) {
+	if err := h.ingestor.Touch(context.Background(), sensorID, sensorName, time.Now().UTC()); err != nil {
+		h.errorLog.Printf("Failed to update last_seen of sensor %s %v", sensorID, err)
+	}
+}
+
+func (h *WebSocketHandler) closeWith(conn *websocket.Conn, code int, text string) {
+	_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(wsWriteWait))
+}
@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sensor/cmd/api/storage"
+)
+
+type WriterHandler struct {
+	infoLog  *log.Logger
+	errorLog *log.Logger
+	writer   *storage.Writer
+}
+
+func NewWriterHandler(infoLog *log.Logger, errorLog *log.Logger, writer *storage.Writer) *WriterHandler {
+	return &WriterHandler{
+		infoLog:  infoLog,
+		errorLog: errorLog,
+		writer:   writer,
+	}
+}
+
+// Stats reports the depth of the write queue and how long batches take
+// to commit.
+func (h *WriterHandler) Stats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.writer.Stats()); err != nil {
+		h.errorLog.Println(err)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sensor/cmd/api/calibration"
+	"sensor/cmd/api/csvimport"
+	"sensor/cmd/api/db"
+	"sensor/cmd/api/derive"
+	"sensor/cmd/api/ingest"
+	"sensor/cmd/api/settings"
+	"sensor/cmd/api/storage"
+	"sensor/cmd/api/throttle"
+	"sensor/cmd/api/units"
+	"sensor/cmd/api/validation"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// runImportCSV implements `air-server import-csv`, which loads a CSV file
+// into the -db file without starting the server.
+func runImportCSV(args []string) int {
+	fs := flag.NewFlagSet("import-csv", flag.ExitOnError)
+	dbPath := fs.String("db", "api.db", "The path to db file")
+	file := fs.String("file", "-", "CSV file to import, - for stdin")
+	var columns, defaults stringsFlag
+	fs.Var(&columns, "col", "Column mapping field=Header, repeatable (fields: timestamp, sensor_id, sensor_name, measurement, parameter, value, unit)")
+	fs.Var(&defaults, "default", "Constant field=value for fields without a column, repeatable")
+	delimiter := fs.String("delimiter", ",", "Field delimiter")
+	timeFormat := fs.String("time-format", "", "Go time layout of the timestamp column (auto-detected when empty)")
+	batchSize := fs.Int("batch-size", 500, "Rows per transaction")
+	dryRun := fs.Bool("dry-run", false, "Only validate and report, do not write")
+	ignoreMaxAge := fs.Bool("ignore-max-age", false, "Import rows older than the max_age setting (31 days by default), which are rejected otherwise. The server deletes them on its next cleanup unless max_age is raised first")
+	fs.Parse(args)
+
+	infoLog := log.New(os.Stderr, "INFO: ", log.Ldate|log.Ltime)
+	errorLog := log.New(os.Stderr, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	opts := csvimport.Options{TimeFormat: *timeFormat, BatchSize: *batchSize, DryRun: *dryRun}
+	var err error
+	if opts.Columns, err = csvimport.ParseAssignments(columns); err != nil {
+		errorLog.Printf("-col: %v", err)
+		return 2
+	}
+	if opts.Defaults, err = csvimport.ParseAssignments(defaults); err != nil {
+		errorLog.Printf("-default: %v", err)
+		return 2
+	}
+	d := *delimiter
+	if d == `\t` {
+		d = "\t"
+	}
+	if utf8.RuneCountInString(d) != 1 {
+		errorLog.Println("-delimiter must be a single character")
+		return 2
+	}
+	opts.Delimiter, _ = utf8.DecodeRuneInString(d)
+
+	var src io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			errorLog.Println(err)
+			return 1
+		}
+		defer f.Close()
+		src = f
+	}
+
+	database, err := db.NewDB(*dbPath)
+	if err != nil {
+		errorLog.Println(err)
+		return 1
+	}
+	defer database.Close()
+
+	ctx := context.Background()
+	store := storage.NewSQLStorage(database, infoLog, errorLog)
+	if err := store.InitDB(ctx); err != nil {
+		errorLog.Println(err)
+		return 1
+	}
+	if err := storage.NewMigrations(database, infoLog).Run(); err != nil {
+		errorLog.Println(err)
+		return 1
+	}
+	var settingsCache settings.SettingsCache
+	if err := InitSettings(ctx, store, &settingsCache, errorLog); err != nil {
+		errorLog.Println(err)
+		return 1
+	}
+
+	rules := validation.NewRules()
+	if err := InitValidationRules(ctx, store, rules); err != nil {
+		errorLog.Println(err)
+		return 1
+	}
+
+	unitRegistry := units.NewRegistry()
+	if err := InitUnits(ctx, store, unitRegistry); err != nil {
+		errorLog.Println(err)
+		return 1
+	}
+
+	profiles := calibration.NewProfiles()
+	if err := InitCalibration(ctx, store, profiles); err != nil {
+		errorLog.Println(err)
+		return 1
+	}
+
+	formulas := derive.NewFormulas()
+	if err := InitFormulas(ctx, store, formulas); err != nil {
+		errorLog.Println(err)
+		return 1
+	}
+
+	writer := storage.NewWriter(infoLog, errorLog, store, storage.WriterConfig{Timeout: time.Minute})
+	writer.Start()
+	defer writer.Close()
+
+	// Bulk writes never publish, so the importer needs no SSE broker.
+	ingestor := ingest.NewIngestor(infoLog, errorLog, store, writer, &settingsCache, throttle.NewStoreThrottle(&settingsCache), throttle.NewAggregator(), rules, unitRegistry, profiles, formulas, nil)
+	bulk := ingestor.NewBulk()
+	if *ignoreMaxAge {
+		bulk.IgnoreMaxAge()
+	} else {
+		infoLog.Printf("Rows older than max_age %s are rejected, see -ignore-max-age", settingsCache.GetMaxAge())
+	}
+	report, importErr := csvimport.Import(ctx, src, bulk, opts)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		errorLog.Println(err)
+	}
+	if importErr != nil {
+		errorLog.Println(importErr)
+		return 1
+	}
+	if report.Rejected > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d rows rejected\n", report.Rejected, report.Rows)
+		return 3
+	}
+	return 0
+}
@@ -0,0 +1,183 @@
+// Package influx parses InfluxDB line protocol
+package influx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrBadLine = errors.New("invalid line protocol")
+
+type Field struct {
+	Key   string
+	Value float64
+}
+
+// Point is one parsed line. Only numeric and boolean fields are kept;
+// string fields are ignored. Timestamp is zero when the line has none.
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      []Field
+	Timestamp   time.Time
+}
+
+// ParsePrecision converts the precision query parameter of the v1 and v2
+// write APIs into the duration of one timestamp unit.
+func ParsePrecision(s string) (time.Duration, error) {
+	switch s {
+	case "", "ns", "n":
+		return time.Nanosecond, nil
+	case "us", "u":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	}
+	return 0, fmt.Errorf("unsupported precision %q", s)
+}
+
+// ParseLine parses a single non-empty, non-comment line.
+func ParseLine(line string, precision time.Duration) (Point, error) {
+	sections := split(line, ' ', true)
+	if len(sections) < 2 || len(sections) > 3 {
+		return Point{}, fmt.Errorf("%w: expected measurement, fields and optional timestamp", ErrBadLine)
+	}
+
+	var p Point
+	keys := split(sections[0], ',', false)
+	p.Measurement = unescape(keys[0])
+	if len(p.Measurement) == 0 {
+		return Point{}, fmt.Errorf("%w: missing measurement", ErrBadLine)
+	}
+	p.Tags = make(map[string]string, len(keys)-1)
+	for _, tag := range keys[1:] {
+		kv := split(tag, '=', false)
+		if len(kv) != 2 || len(kv[0]) == 0 || len(kv[1]) == 0 {
+			return Point{}, fmt.Errorf("%w: bad tag %q", ErrBadLine, tag)
+		}
+		p.Tags[unescape(kv[0])] = unescape(kv[1])
+	}
+
+	for _, field := range split(sections[1], ',', true) {
+		key, raw, ok := cutUnescaped(field, '=')
+		if !ok || len(key) == 0 || len(raw) == 0 {
+			return Point{}, fmt.Errorf("%w: bad field %q", ErrBadLine, field)
+		}
+		value, numeric, err := parseFieldValue(raw)
+		if err != nil {
+			return Point{}, fmt.Errorf("%w: field %q: %v", ErrBadLine, unescape(key), err)
+		}
+		if numeric {
+			p.Fields = append(p.Fields, Field{Key: unescape(key), Value: value})
+		}
+	}
+
+	if len(sections) == 3 {
+		ts, err := strconv.ParseInt(sections[2], 10, 64)
+		if err != nil {
+			return Point{}, fmt.Errorf("%w: bad timestamp %q", ErrBadLine, sections[2])
+		}
+		// Nanoseconds since the epoch must fit in an int64, as in InfluxDB.
+		if unit := int64(precision); ts > math.MaxInt64/unit || ts < math.MinInt64/unit {
+			return Point{}, fmt.Errorf("%w: timestamp %q out of range", ErrBadLine, sections[2])
+		}
+		p.Timestamp = time.Unix(0, ts*int64(precision)).UTC()
+	}
+	return p, nil
+}
+
+func parseFieldValue(raw string) (float64, bool, error) {
+	if strings.HasPrefix(raw, `"`) {
+		if len(raw) < 2 || !strings.HasSuffix(raw, `"`) {
+			return 0, false, errors.New("unterminated string")
+		}
+		return 0, false, nil
+	}
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, true, nil
+	}
+
+	var value float64
+	switch raw[len(raw)-1] {
+	case 'i':
+		n, err := strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+		if err != nil {
+			return 0, false, errors.New("bad integer")
+		}
+		value = float64(n)
+	case 'u':
+		n, err := strconv.ParseUint(raw[:len(raw)-1], 10, 64)
+		if err != nil {
+			return 0, false, errors.New("bad unsigned integer")
+		}
+		value = float64(n)
+	default:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return 0, false, errors.New("bad float")
+		}
+		value = f
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, false, errors.New("value is not finite")
+	}
+	return value, true, nil
+}
+
+// split cuts s at every sep that is neither backslash-escaped nor, when
+// quoted is set, inside a double-quoted string. Escapes are preserved.
+func split(s string, sep byte, quoted bool) []string {
+	var out []string
+	start := 0
+	inQuote := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quoted && s[i] == '"':
+			inQuote = !inQuote
+		case s[i] == sep && !inQuote:
+			out = append(out, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(out, s[start:])
+}
+
+func cutUnescaped(s string, sep byte) (before, after string, found bool) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			return s[:i], s[i+1:], true
+		}
+	}
+	return s, "", false
+}
+
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case ',', '=', ' ', '"', '\\':
+				i++
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,173 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"sensor/cmd/api/models"
+	"sensor/cmd/api/storage"
+	"sensor/cmd/api/validation"
+	"time"
+)
+
+// Bulk collects lines of a bulk upload that the caller commits every few
+// lines as a single writer job. Like Backfill it bypasses the
+// store_interval throttle and does not publish to live subscribers.
+type Bulk struct {
+	ingestor     *Ingestor
+	ignoreMaxAge bool
+	lines        []bulkLine
+	duplicates   int
+	quarantined  int
+}
+
+// bulkLine is a line checked and calibrated ahead of its write.
+type bulkLine struct {
+	line     models.BulkMeasurementLine
+	values   []models.MeasurementValue
+	rejected []rejection
+	ts       time.Time
+}
+
+func (i *Ingestor) NewBulk() *Bulk {
+	return &Bulk{ingestor: i}
+}
+
+// IgnoreMaxAge lets lines older than max_age through, for offline imports
+// of history. They are still deleted by the next cleanup of a server that
+// keeps the same max_age.
+func (b *Bulk) IgnoreMaxAge() {
+	b.ignoreMaxAge = true
+}
+
+// Add validates one line and queues it for the next Commit. Validation
+// errors wrap models.ErrBadPayload; a line whose every reading was
+// quarantined is reported the same way, though its readings still go to
+// quarantine on Commit.
+func (b *Bulk) Add(ctx context.Context, line *models.BulkMeasurementLine) error {
+	values, ts, err := b.prepare(line)
+	if err != nil {
+		return err
+	}
+
+	values, rejected := b.ingestor.screen(values)
+	b.ingestor.calibrate(ctx, line.SensorID, values, ts)
+	b.lines = append(b.lines, bulkLine{line: *line, values: b.ingestor.derive(values), rejected: rejected, ts: ts})
+	if len(values) == 0 {
+		return fmt.Errorf("%w: quarantined: %s", models.ErrBadPayload, rejected[0].reason)
+	}
+	return nil
+}
+
+// Check validates a line the same way Add does without storing it.
+func (b *Bulk) Check(line *models.BulkMeasurementLine) error {
+	values, _, err := b.prepare(line)
+	if err != nil {
+		return err
+	}
+	var reason string
+	for _, v := range values {
+		verdict := b.ingestor.rules.Check(&v)
+		if verdict.Action != validation.ActionReject {
+			return nil
+		}
+		reason = verdict.Reason
+	}
+	return fmt.Errorf("%w: quarantined: %s", models.ErrBadPayload, reason)
+}
+
+func (b *Bulk) prepare(line *models.BulkMeasurementLine) ([]models.MeasurementValue, time.Time, error) {
+	var oldest time.Time
+	if !b.ignoreMaxAge {
+		oldest = time.Now().UTC().Add(-b.ingestor.settings.GetMaxAge())
+	}
+	if err := line.Validate(oldest); err != nil {
+		return nil, time.Time{}, err
+	}
+	values, err := b.ingestor.extractValues(&line.CreateMeasurementReq)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	ts := line.Timestamp.UTC()
+	if line.Timestamp.IsZero() {
+		ts = time.Now().UTC()
+	}
+	return values, ts, nil
+}
+
+// Len returns the number of lines waiting for Commit, including lines
+// whose readings were all quarantined.
+func (b *Bulk) Len() int {
+	return len(b.lines)
+}
+
+// Duplicates returns the number of committed readings that were already
+// stored and therefore not inserted again.
+func (b *Bulk) Duplicates() int {
+	return b.duplicates
+}
+
+// Quarantined returns the number of committed readings that were moved to
+// quarantine by a validation rule.
+func (b *Bulk) Quarantined() int {
+	return b.quarantined
+}
+
+// Commit writes the waiting lines in one writer job. On error none of them
+// are stored. Either way they are no longer waiting.
+func (b *Bulk) Commit(ctx context.Context) error {
+	if len(b.lines) == 0 {
+		return nil
+	}
+	lines := b.lines
+	b.lines = nil
+
+	var duplicates map[string]int
+	quarantined := 0
+	err := b.ingestor.writer.Do(ctx, func(ctx context.Context, tx *storage.Tx) error {
+		duplicates = make(map[string]int)
+		quarantined = 0
+		for _, l := range lines {
+			line := &l.line
+			records, err := b.ingestor.quarantine(ctx, tx, line.SensorID, line.SensorName, l.rejected, l.ts, line.MessageID)
+			if err != nil {
+				return err
+			}
+			quarantined += len(records)
+			if len(l.values) == 0 {
+				continue
+			}
+
+			if err := tx.UpsertSensor(ctx, &line.SensorID, &line.SensorName, l.ts); err != nil {
+				return &StoreError{Step: StepSensor, SensorID: line.SensorID, Err: err}
+			}
+			rule := b.ingestor.dedupRule(line.MessageID)
+			for _, v := range l.values {
+				if err := tx.UpdateSensorMeasurement(ctx, line.SensorID, v.Measurement); err != nil {
+					return &StoreError{Step: StepCatalog, SensorID: line.SensorID, Measurement: v.Measurement, Err: err}
+				}
+				record, err := tx.CreateMeasurement(ctx, &line.SensorID, &line.SensorName, &v, l.ts, rule)
+				if err != nil {
+					return &StoreError{Step: StepCreate, SensorID: line.SensorID, Measurement: v.Measurement, Err: err}
+				}
+				if record.Duplicate {
+					duplicates[line.SensorID]++
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for sensorID, n := range duplicates {
+		b.ingestor.dedup.AddDuplicates(sensorID, n)
+		b.duplicates += n
+	}
+	b.quarantined += quarantined
+	return nil
+}
+
+// Rollback discards the lines waiting for Commit.
+func (b *Bulk) Rollback() {
+	b.lines = nil
+}
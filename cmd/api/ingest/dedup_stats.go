@@ -0,0 +1,65 @@
+package ingest
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type SensorDedupStats struct {
+	SensorID          string    `json:"sensor_id"`
+	DuplicateReadings uint64    `json:"duplicate_readings"`
+	IdempotentReplays uint64    `json:"idempotent_replays"`
+	LastHit           time.Time `json:"last_hit"`
+}
+
+// DedupStats counts per sensor the readings dropped as duplicates and the
+// requests answered from a stored Idempotency-Key response.
+type DedupStats struct {
+	mu    sync.Mutex
+	stats map[string]*SensorDedupStats
+}
+
+func NewDedupStats() *DedupStats {
+	return &DedupStats{stats: make(map[string]*SensorDedupStats)}
+}
+
+func (d *DedupStats) AddDuplicates(sensorID string, n int) {
+	if n == 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := d.sensor(sensorID)
+	s.DuplicateReadings += uint64(n)
+	s.LastHit = time.Now().UTC()
+}
+
+func (d *DedupStats) AddReplay(sensorID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := d.sensor(sensorID)
+	s.IdempotentReplays++
+	s.LastHit = time.Now().UTC()
+}
+
+func (d *DedupStats) sensor(sensorID string) *SensorDedupStats {
+	s, ok := d.stats[sensorID]
+	if !ok {
+		s = &SensorDedupStats{SensorID: sensorID}
+		d.stats[sensorID] = s
+	}
+	return s
+}
+
+func (d *DedupStats) Snapshot() []SensorDedupStats {
+	d.mu.Lock()
+	out := make([]SensorDedupStats, 0, len(d.stats))
+	for _, s := range d.stats {
+		out = append(out, *s)
+	}
+	d.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].SensorID < out[j].SensorID })
+	return out
+}
@@ -0,0 +1,57 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sensor/cmd/api/storage"
+)
+
+// Steps of a write that a StoreError can point to.
+const (
+	StepQuarantine = "quarantine"
+	StepSensor     = "upsert_sensor"
+	StepCatalog    = "update_catalog"
+	StepDedup      = "find_duplicate"
+	StepCreate     = "create_measurement"
+	StepAggregate  = "aggregate_measurement"
+	StepCommit     = "commit"
+)
+
+// StoreError reports the write that failed while storing a request. None
+// of the request was stored, since its writes share one transaction.
+type StoreError struct {
+	Step        string
+	SensorID    string
+	Measurement string
+	Err         error
+}
+
+func (e *StoreError) Error() string {
+	if e.Measurement != "" {
+		return fmt.Sprintf("%s %s of sensor %s: %v", e.Step, e.Measurement, e.SensorID, e.Err)
+	}
+	return fmt.Sprintf("%s of sensor %s: %v", e.Step, e.SensorID, e.Err)
+}
+
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
+
+// writeError attributes an error returned by the writer. Errors of a step
+// are already a StoreError, back-pressure is passed on as is and anything
+// else failed the commit.
+func writeError(sensorID string, err error) error {
+	var storeErr *StoreError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &storeErr),
+		errors.Is(err, storage.ErrWriterBusy),
+		errors.Is(err, storage.ErrWriterClosed),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, context.Canceled):
+		return err
+	}
+	return &StoreError{Step: StepCommit, SensorID: sensorID, Err: err}
+}
@@ -0,0 +1,492 @@
+// Package ingest stores incoming readings and fans them out to live clients
+package ingest
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sensor/cmd/api/calibration"
+	"sensor/cmd/api/derive"
+	"sensor/cmd/api/models"
+	"sensor/cmd/api/settings"
+	"sensor/cmd/api/storage"
+	"sensor/cmd/api/throttle"
+	"sensor/cmd/api/units"
+	"sensor/cmd/api/validation"
+	"time"
+)
+
+// Publisher delivers freshly ingested readings to live subscribers.
+type Publisher interface {
+	Publish(sensorID string, measurements []models.MeasurementSSE)
+}
+
+// Result describes what happened to the readings of one request. Records
+// holds newly stored rows and, flagged as Duplicate, the earlier rows of
+// readings that were already stored; Duplicates counts the latter.
+// Aggregated counts readings folded into an open aggregate window.
+// Quarantined holds readings rejected by a validation rule. When Records,
+// Aggregated and Quarantined are empty the readings were skipped. Items
+// tells what became of each reading, in request order.
+type Result struct {
+	Records     []storage.MeasurementRecord
+	Aggregated  int
+	Duplicates  int
+	Quarantined []storage.QuarantineRecord
+	Items       []ItemResult
+}
+
+// rejection is a reading that a validation rule sends to quarantine.
+// index is its position in the values passed to screen.
+type rejection struct {
+	value  models.MeasurementValue
+	reason string
+	index  int
+}
+
+type Ingestor struct {
+	infoLog     *log.Logger
+	errorLog    *log.Logger
+	storage     *storage.SQLStorage
+	writer      *storage.Writer
+	settings    *settings.SettingsCache
+	throttle    *throttle.StoreThrottle
+	aggregator  *throttle.Aggregator
+	rules       *validation.Rules
+	units       *units.Registry
+	calibration *calibration.Profiles
+	formulas    *derive.Formulas
+	publisher   Publisher
+	dedup       *DedupStats
+}
+
+func NewIngestor(infoLog *log.Logger, errorLog *log.Logger, storage *storage.SQLStorage, writer *storage.Writer, settings *settings.SettingsCache, throttle *throttle.StoreThrottle, aggregator *throttle.Aggregator, rules *validation.Rules, units *units.Registry, calibration *calibration.Profiles, formulas *derive.Formulas, publisher Publisher) *Ingestor {
+	return &Ingestor{
+		infoLog:     infoLog,
+		errorLog:    errorLog,
+		storage:     storage,
+		writer:      writer,
+		settings:    settings,
+		throttle:    throttle,
+		aggregator:  aggregator,
+		rules:       rules,
+		units:       units,
+		calibration: calibration,
+		formulas:    formulas,
+		publisher:   publisher,
+		dedup:       NewDedupStats(),
+	}
+}
+
+// Dedup returns the duplicate counters of this ingestor.
+func (i *Ingestor) Dedup() *DedupStats {
+	return i.dedup
+}
+
+// dedupRule builds the rule for a reading from its message_id and the
+// dedup_mode setting.
+func (i *Ingestor) dedupRule(messageID *string) storage.DedupRule {
+	return storage.DedupRule{
+		MessageID:   messageID,
+		ByTimestamp: i.settings.GetDedupMode() == settings.DedupModeTimestamp,
+	}
+}
+
+// extractValues returns the readings of req converted to the canonical
+// unit of their measurement.
+func (i *Ingestor) extractValues(req *models.CreateMeasurementReq) ([]models.MeasurementValue, error) {
+	values, err := req.ExtractValues()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]models.MeasurementValue, len(values))
+	for n, v := range values {
+		if err := i.units.Normalize(&v); err != nil {
+			return nil, err
+		}
+		out[n] = v
+	}
+	return out, nil
+}
+
+// humidityWindow is how far from a reading a stored humidity may be to
+// feed a humidity compensated calibration.
+const humidityWindow = 10 * time.Minute
+
+// calibrate applies the calibration profile in effect at ts to each value,
+// keeping the value as received in RawValue. values are the readings kept
+// by screen, so rules see readings as received and a quarantined humidity
+// never corrects another reading. Humidity compensated profiles use the
+// calibrated humidity of the same request, or the closest stored one;
+// without either the value is stored uncalibrated.
+func (i *Ingestor) calibrate(ctx context.Context, sensorID string, values []models.MeasurementValue, ts time.Time) {
+	var humidity *float64
+	for _, pass := range []func(calibration.Profile) bool{
+		func(p calibration.Profile) bool { return p.Kind != calibration.KindEPAPurpleAir },
+		func(p calibration.Profile) bool { return p.Kind == calibration.KindEPAPurpleAir },
+	} {
+		for n := range values {
+			v := &values[n]
+			profile, ok := i.calibration.Active(sensorID, v.Measurement, ts)
+			if !ok || !pass(profile) {
+				continue
+			}
+			if profile.Kind == calibration.KindEPAPurpleAir && humidity == nil {
+				humidity = i.humidity(ctx, sensorID, values, ts)
+			}
+			value, err := profile.Apply(v.Value, humidity)
+			if err != nil {
+				i.errorLog.Printf("Calibration %d of sensor %s %s skipped %v", profile.ID, sensorID, v.Measurement, err)
+				continue
+			}
+			if v.RawValue == nil {
+				raw := v.Value
+				v.RawValue = &raw
+				v.RawUnit = v.Unit
+			}
+			v.Value = value
+			id := profile.ID
+			v.CalibrationID = &id
+		}
+	}
+}
+
+// humidity returns the relative humidity at ts from values, or else the
+// closest one stored for the sensor.
+func (i *Ingestor) humidity(ctx context.Context, sensorID string, values []models.MeasurementValue, ts time.Time) *float64 {
+	for _, v := range values {
+		if v.Measurement == calibration.HumidityMeasurement {
+			h := v.Value
+			return &h
+		}
+	}
+	h, ok, err := i.storage.NearestValue(ctx, sensorID, calibration.HumidityMeasurement, ts, humidityWindow)
+	if err != nil {
+		i.errorLog.Printf("Failed to look up humidity of sensor %s %v", sensorID, err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	return &h
+}
+
+// screen applies validation rules to values. Rejected readings are left
+// out of the returned values for the caller to quarantine; clamped and
+// flagged readings carry the reason in Flag.
+func (i *Ingestor) screen(values []models.MeasurementValue) ([]models.MeasurementValue, []rejection) {
+	var rejected []rejection
+	kept := values[:0:0]
+	for n, v := range values {
+		verdict := i.rules.Check(&v)
+		switch verdict.Action {
+		case validation.ActionReject:
+			rejected = append(rejected, rejection{value: v, reason: verdict.Reason, index: n})
+			continue
+		case validation.ActionClamp, validation.ActionFlag:
+			reason := verdict.Reason
+			v.Value = verdict.Value
+			v.Flag = &reason
+		}
+		kept = append(kept, v)
+	}
+	return kept, rejected
+}
+
+// derive appends the readings that the derived formulas compute from the
+// screened values, so that quarantined readings feed none of them.
+func (i *Ingestor) derive(values []models.MeasurementValue) []models.MeasurementValue {
+	return append(values, i.formulas.Derive(values)...)
+}
+
+// quarantine writes rejected readings to quarantine in tx.
+func (i *Ingestor) quarantine(ctx context.Context, tx *storage.Tx, sensorID, sensorName string, rejected []rejection, ts time.Time, messageID *string) ([]storage.QuarantineRecord, error) {
+	var records []storage.QuarantineRecord
+	for _, r := range rejected {
+		record, err := tx.Quarantine(ctx, &sensorID, &sensorName, &r.value, ts, messageID, r.reason)
+		if err != nil {
+			return nil, &StoreError{Step: StepQuarantine, SensorID: sensorID, Measurement: r.value.Measurement, Err: err}
+		}
+		i.infoLog.Printf("Quarantined reading from sensor %s: %s", sensorID, r.reason)
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// Ingest applies store_interval throttling to a live reading, stores or
+// aggregates it through the writer and, once committed, records its store
+// time and publishes it to live subscribers.
+func (i *Ingestor) Ingest(ctx context.Context, sensorID string, req *models.CreateMeasurementReq) (Result, error) {
+	values, err := i.extractValues(req)
+	if err != nil {
+		return Result{}, err
+	}
+	return i.ingest(ctx, sensorID, req, values)
+}
+
+// maxClockSkew is how far ahead of the server clock a live reading's
+// timestamp may be, for device clocks that run slightly fast.
+const maxClockSkew = 5 * time.Minute
+
+// ingest stores the readings values of req, which are already converted to
+// their canonical unit. A device timestamp older than max_age, which the
+// next cleanup would delete, or more than maxClockSkew ahead is rejected.
+func (i *Ingestor) ingest(ctx context.Context, sensorID string, req *models.CreateMeasurementReq, values []models.MeasurementValue) (Result, error) {
+	currTimestamp := time.Now().UTC()
+	if err := req.ValidateTimestamp(currTimestamp.Add(-i.settings.GetMaxAge()), currTimestamp.Add(maxClockSkew)); err != nil {
+		return Result{}, err
+	}
+	ts := req.Timestamp
+	if ts.IsZero() {
+		ts = currTimestamp
+	}
+	// Stored times have millisecond precision; live subscribers get the
+	// same time as the stored record.
+	ts = ts.UTC().Truncate(time.Millisecond)
+	aggregate := i.settings.GetStoreMode() == settings.StoreModeAggregate
+	storeInterval := i.settings.GetStoreInterval()
+	rule := i.dedupRule(req.MessageID)
+
+	items := make([]ItemResult, len(values))
+	values, rejected := i.screen(values)
+	indexes := keptIndexes(len(items), rejected)
+	i.calibrate(ctx, sensorID, values, ts)
+	values = i.derive(values)
+
+	var result Result
+	var sseResponse []models.MeasurementSSE
+	// opened holds the aggregate windows to open once the records that
+	// back them are committed.
+	var opened []storage.MeasurementRecord
+	// reserved holds the store times to confirm once committed.
+	var reserved []throttle.Key
+	err := i.writer.Do(ctx, func(ctx context.Context, tx *storage.Tx) error {
+		reserved = nil
+		quarantined, err := i.quarantine(ctx, tx, sensorID, req.SensorName, rejected, ts, req.MessageID)
+		if err != nil {
+			return err
+		}
+		result.Quarantined = quarantined
+		for n, r := range rejected {
+			items[r.index] = quarantinedItem(quarantined[n])
+		}
+
+		if len(values) > 0 {
+			if err := tx.UpsertSensor(ctx, &sensorID, &req.SensorName, ts); err != nil {
+				return &StoreError{Step: StepSensor, SensorID: sensorID, Err: err}
+			}
+		}
+		decisions := make(map[throttle.Key]bool)
+		for n, v := range values {
+			// Derived readings are not items of the request.
+			item := &ItemResult{}
+			if n < len(indexes) {
+				item = &items[indexes[n]]
+			}
+			*item = ItemResult{Status: ItemSkipped, Reason: ReasonInterval}
+			if err := tx.UpdateSensorMeasurement(ctx, sensorID, v.Measurement); err != nil {
+				return &StoreError{Step: StepCatalog, SensorID: sensorID, Measurement: v.Measurement, Err: err}
+			}
+
+			// Repeated readings are answered with the stored record before
+			// they can count against store_interval or an aggregate window.
+			if rule.Active() {
+				record, found, err := tx.FindDuplicate(ctx, sensorID, &v, ts, rule)
+				if err != nil {
+					return &StoreError{Step: StepDedup, SensorID: sensorID, Measurement: v.Measurement, Err: err}
+				}
+				if found {
+					result.Records = append(result.Records, record)
+					result.Duplicates++
+					*item = recordItem(ItemSkipped, ReasonDuplicate, record.ID)
+					continue
+				}
+			}
+
+			var m models.MeasurementSSE
+			m.SensorID = &sensorID
+			m.SensorName = &req.SensorName
+			m.Measurement = v.Measurement
+			m.Parameter = v.Parameter
+			m.Value = v.Value
+			m.Unit = v.Unit
+			m.Derived = v.Derived
+			m.Timestamp = ts
+			sseResponse = append(sseResponse, m)
+
+			key := i.throttle.Key(sensorID, v.Measurement)
+			shouldStore, ok := decisions[key]
+			if !ok {
+				shouldStore = i.throttle.Reserve(key, currTimestamp)
+				decisions[key] = shouldStore
+				if shouldStore {
+					reserved = append(reserved, key)
+				}
+			}
+			// In aggregate mode a reading is folded into the open window of
+			// its series. One without a window, as after a restart or once
+			// the window's record was deleted, is stored and opens one.
+			if !shouldStore && aggregate {
+				if recordID, open := i.aggregator.RecordID(sensorID, &v, currTimestamp, storeInterval); open {
+					_, err := tx.AggregateMeasurement(ctx, recordID, v.Value)
+					switch {
+					case errors.Is(err, storage.ErrMeasurementNotFound):
+						i.aggregator.Close(sensorID, &v, recordID)
+					case err != nil:
+						return &StoreError{Step: StepAggregate, SensorID: sensorID, Measurement: v.Measurement, Err: err}
+					default:
+						result.Aggregated++
+						*item = recordItem(ItemStored, ReasonAggregated, recordID)
+						continue
+					}
+				}
+				shouldStore = true
+			}
+			if !shouldStore {
+				continue
+			}
+
+			record, err := tx.CreateMeasurement(ctx, &sensorID, &req.SensorName, &v, ts, rule)
+			if err != nil {
+				return &StoreError{Step: StepCreate, SensorID: sensorID, Measurement: v.Measurement, Err: err}
+			}
+			result.Records = append(result.Records, record)
+			if record.Duplicate {
+				result.Duplicates++
+				*item = recordItem(ItemSkipped, ReasonDuplicate, record.ID)
+				continue
+			}
+			*item = recordItem(ItemStored, "", record.ID)
+			if aggregate {
+				opened = append(opened, record)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		for _, key := range reserved {
+			i.throttle.Release(key, currTimestamp)
+		}
+		return Result{}, writeError(sensorID, err)
+	}
+	for _, key := range reserved {
+		i.throttle.Confirm(key, currTimestamp)
+	}
+	for n := range items {
+		items[n].Index = n
+	}
+	result.Items = items
+
+	for _, record := range opened {
+		v := models.MeasurementValue{Measurement: record.Measurement, Parameter: record.Parameter}
+		i.aggregator.Open(sensorID, &v, record.ID, currTimestamp, storeInterval)
+	}
+	if len(sseResponse) > 0 {
+		i.publisher.Publish(sensorID, sseResponse)
+	}
+	if result.Duplicates > 0 {
+		i.dedup.AddDuplicates(sensorID, result.Duplicates)
+		i.infoLog.Printf("Dropped %d duplicate readings from sensor %s", result.Duplicates, sensorID)
+	}
+
+	return result, nil
+}
+
+// backfillReading is a backfill reading checked and calibrated ahead of
+// its write.
+type backfillReading struct {
+	sensorName string
+	values     []models.MeasurementValue
+	rejected   []rejection
+	ts         time.Time
+	messageID  *string
+}
+
+// Backfill stores historical readings buffered by a device while it was
+// offline. Readings may arrive in any order, bypass the store_interval
+// throttle and are not published to live subscribers. Readings rejected by
+// a validation rule are quarantined and left out of the returned records.
+// All readings are written in one job, so either all or none are stored.
+func (i *Ingestor) Backfill(ctx context.Context, sensorID string, req *models.BackfillMeasurementReq) ([]storage.MeasurementRecord, error) {
+	oldest := time.Now().UTC().Add(-i.settings.GetMaxAge())
+	if err := req.Validate(oldest); err != nil {
+		return nil, err
+	}
+
+	readings := make([]backfillReading, 0, len(req.Readings))
+	for _, reading := range req.Readings {
+		values, err := i.extractValues(&reading)
+		if err != nil {
+			return nil, err
+		}
+		sensorName := reading.SensorName
+		if len(sensorName) == 0 {
+			sensorName = req.SensorName
+		}
+		ts := reading.Timestamp.UTC()
+		values, rejected := i.screen(values)
+		i.calibrate(ctx, sensorID, values, ts)
+		values = i.derive(values)
+		readings = append(readings, backfillReading{
+			sensorName: sensorName,
+			values:     values,
+			rejected:   rejected,
+			ts:         ts,
+			messageID:  reading.MessageID,
+		})
+	}
+
+	var records []storage.MeasurementRecord
+	duplicates := 0
+	err := i.writer.Do(ctx, func(ctx context.Context, tx *storage.Tx) error {
+		records = []storage.MeasurementRecord{}
+		for _, r := range readings {
+			if _, err := i.quarantine(ctx, tx, sensorID, r.sensorName, r.rejected, r.ts, r.messageID); err != nil {
+				return err
+			}
+			if len(r.values) == 0 {
+				continue
+			}
+
+			if err := tx.UpsertSensor(ctx, &sensorID, &r.sensorName, r.ts); err != nil {
+				return &StoreError{Step: StepSensor, SensorID: sensorID, Err: err}
+			}
+			rule := i.dedupRule(r.messageID)
+			for _, v := range r.values {
+				if err := tx.UpdateSensorMeasurement(ctx, sensorID, v.Measurement); err != nil {
+					return &StoreError{Step: StepCatalog, SensorID: sensorID, Measurement: v.Measurement, Err: err}
+				}
+
+				record, err := tx.CreateMeasurement(ctx, &sensorID, &r.sensorName, &v, r.ts, rule)
+				if err != nil {
+					return &StoreError{Step: StepCreate, SensorID: sensorID, Measurement: v.Measurement, Err: err}
+				}
+				if record.Duplicate {
+					duplicates++
+				}
+				records = append(records, record)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, writeError(sensorID, err)
+	}
+	if duplicates > 0 {
+		i.dedup.AddDuplicates(sensorID, duplicates)
+		i.infoLog.Printf("Dropped %d duplicate backfill readings from sensor %s", duplicates, sensorID)
+	}
+	return records, nil
+}
+
+// Touch marks a sensor as seen at the given time without storing a
+// reading, e.g. while it holds a connection open.
+func (i *Ingestor) Touch(ctx context.Context, sensorID, sensorName string, at time.Time) error {
+	err := i.writer.Do(ctx, func(ctx context.Context, tx *storage.Tx) error {
+		if err := tx.UpsertSensor(ctx, &sensorID, &sensorName, at); err != nil {
+			return &StoreError{Step: StepSensor, SensorID: sensorID, Err: err}
+		}
+		return nil
+	})
+	return writeError(sensorID, err)
+}
@@ -0,0 +1,107 @@
+package ingest
+
+import (
+	"context"
+	"sensor/cmd/api/models"
+	"sensor/cmd/api/storage"
+)
+
+// Statuses of a reading in ItemResult.
+const (
+	ItemStored   = "stored"
+	ItemSkipped  = "skipped"
+	ItemRejected = "rejected"
+)
+
+// Reason codes of an ItemResult.
+const (
+	ReasonDuplicate          = "duplicate"
+	ReasonAggregated         = "aggregated"
+	ReasonInterval           = "interval_not_reached"
+	ReasonQuarantined        = "quarantined"
+	ReasonMissingMeasurement = "missing_measurement"
+	ReasonInvalidUnit        = "invalid_unit"
+)
+
+// ItemResult tells what became of one reading of a request. RecordID is
+// the stored record, the record an aggregated reading was folded into or,
+// for a duplicate, the record stored earlier. QuarantineID is set for
+// readings moved to quarantine.
+type ItemResult struct {
+	Index        int    `json:"index"`
+	Status       string `json:"status"`
+	Reason       string `json:"reason,omitempty"`
+	Message      string `json:"message,omitempty"`
+	RecordID     *int64 `json:"record_id,omitempty"`
+	QuarantineID *int64 `json:"quarantine_id,omitempty"`
+}
+
+func recordItem(status, reason string, recordID int64) ItemResult {
+	return ItemResult{Status: status, Reason: reason, RecordID: &recordID}
+}
+
+func quarantinedItem(record storage.QuarantineRecord) ItemResult {
+	id := record.ID
+	return ItemResult{Status: ItemRejected, Reason: ReasonQuarantined, Message: record.Reason, QuarantineID: &id}
+}
+
+// keptIndexes returns the positions among n screened values of the ones
+// screen kept, in order.
+func keptIndexes(n int, rejected []rejection) []int {
+	skip := make(map[int]bool, len(rejected))
+	for _, r := range rejected {
+		skip[r.index] = true
+	}
+	kept := make([]int, 0, n-len(rejected))
+	for k := 0; k < n; k++ {
+		if !skip[k] {
+			kept = append(kept, k)
+		}
+	}
+	return kept
+}
+
+// IngestPartial is Ingest for devices that want the valid readings of a
+// measurements array stored even when others are not. A reading without a
+// measurement or with an unknown unit is reported as rejected in Items
+// instead of failing the request; the rest are ingested like Ingest does.
+// A storage error still fails the whole request.
+func (i *Ingestor) IngestPartial(ctx context.Context, sensorID string, req *models.CreateMeasurementReq) (Result, error) {
+	if len(req.Measurements) == 0 || req.Measurement != nil || req.Parameter != nil || req.Unit != nil {
+		// A single reading, or a payload ExtractValues refuses as a whole.
+		return i.Ingest(ctx, sensorID, req)
+	}
+
+	items := make([]ItemResult, len(req.Measurements))
+	var values []models.MeasurementValue
+	var indexes []int
+	for n, v := range req.Measurements {
+		if len(v.Measurement) == 0 {
+			items[n] = ItemResult{Status: ItemRejected, Reason: ReasonMissingMeasurement, Message: "measurement is required"}
+			continue
+		}
+		if err := i.units.Normalize(&v); err != nil {
+			items[n] = ItemResult{Status: ItemRejected, Reason: ReasonInvalidUnit, Message: err.Error()}
+			continue
+		}
+		values = append(values, v)
+		indexes = append(indexes, n)
+	}
+
+	var result Result
+	if len(values) > 0 {
+		var err error
+		result, err = i.ingest(ctx, sensorID, req, values)
+		if err != nil {
+			return Result{}, err
+		}
+		for k, item := range result.Items {
+			items[indexes[k]] = item
+		}
+	}
+	for n := range items {
+		items[n].Index = n
+	}
+	result.Items = items
+	return result, nil
+}
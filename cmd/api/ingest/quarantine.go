@@ -0,0 +1,40 @@
+package ingest
+
+import (
+	"context"
+	"sensor/cmd/api/storage"
+	"time"
+)
+
+// ReleaseQuarantine stores the quarantined reading id as a measurement
+// through the writer. Like a backfilled reading it bypasses store_interval
+// and is not folded into an aggregate window, and it is not published to
+// live subscribers. A release that repeats a stored message_id returns the
+// stored record and counts as a duplicate.
+func (i *Ingestor) ReleaseQuarantine(ctx context.Context, id int64) (storage.MeasurementRecord, error) {
+	var record storage.MeasurementRecord
+	err := i.writer.Do(ctx, func(ctx context.Context, tx *storage.Tx) error {
+		var err error
+		record, err = tx.ReleaseQuarantine(ctx, id)
+		return err
+	})
+	if err != nil {
+		return storage.MeasurementRecord{}, err
+	}
+	if record.Duplicate && record.SensorID != nil {
+		i.dedup.AddDuplicates(*record.SensorID, 1)
+	}
+	return record, nil
+}
+
+// PurgeQuarantine deletes quarantined readings through the writer, as
+// storage.Tx.PurgeQuarantine selects them.
+func (i *Ingestor) PurgeQuarantine(ctx context.Context, id int64, sensorID string, before time.Time) (int64, error) {
+	var n int64
+	err := i.writer.Do(ctx, func(ctx context.Context, tx *storage.Tx) error {
+		var err error
+		n, err = tx.PurgeQuarantine(ctx, id, sensorID, before)
+		return err
+	})
+	return n, err
+}
@@ -0,0 +1,108 @@
+package ingest
+
+import (
+	"context"
+	"sensor/cmd/api/calibration"
+	"sensor/cmd/api/storage"
+	"sensor/cmd/api/units"
+	"time"
+)
+
+const recalibrateBatchSize = 500
+
+// RecalibrateResult counts the records a recalibration rewrote and the
+// aggregated records it left alone, because their raw samples are gone.
+type RecalibrateResult struct {
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+}
+
+// Recalibrate recomputes stored values of a sensor measurement taken in
+// [from, to] from their raw readings with the profiles now in effect, so
+// that a profile added with a past effective_from applies to history.
+// Validation rules are not applied again.
+func (i *Ingestor) Recalibrate(ctx context.Context, sensorID, measurement string, from, to time.Time) (RecalibrateResult, error) {
+	var result RecalibrateResult
+	var afterID int64
+	for {
+		records, err := i.storage.GetMeasurementsForCalibration(ctx, sensorID, measurement, from, to, afterID, recalibrateBatchSize)
+		if err != nil {
+			return result, err
+		}
+		if len(records) == 0 {
+			return result, nil
+		}
+
+		var updates []calibrationUpdate
+		for _, r := range records {
+			afterID = r.ID
+			if r.SampleCount > 1 {
+				result.Skipped++
+				continue
+			}
+
+			// raw is the reading as received, in the record's unit.
+			raw := r.Value
+			if r.RawValue != nil {
+				raw = *r.RawValue
+				if r.RawUnit != nil && r.Unit != nil && *r.RawUnit != *r.Unit {
+					unit, _ := units.Lookup(*r.RawUnit)
+					if raw, err = units.Convert(measurement, raw, unit, *r.Unit); err != nil {
+						i.errorLog.Printf("Recalibrate record %d skipped %v", r.ID, err)
+						result.Skipped++
+						continue
+					}
+				}
+			} else if r.CalibrationID != nil {
+				// Calibrated without a raw reading cannot be undone.
+				result.Skipped++
+				continue
+			}
+
+			value, rawValue, rawUnit := raw, r.RawValue, r.RawUnit
+			var calibrationID *int64
+			if profile, ok := i.calibration.Active(sensorID, measurement, r.Timestamp); ok {
+				var humidity *float64
+				if profile.Kind == calibration.KindEPAPurpleAir {
+					humidity = i.humidity(ctx, sensorID, nil, r.Timestamp)
+				}
+				calibrated, err := profile.Apply(raw, humidity)
+				if err != nil {
+					i.errorLog.Printf("Recalibrate record %d skipped %v", r.ID, err)
+					result.Skipped++
+					continue
+				}
+				if rawValue == nil {
+					rawValue, rawUnit = &raw, r.Unit
+				}
+				id := profile.ID
+				value, calibrationID = calibrated, &id
+			}
+
+			updates = append(updates, calibrationUpdate{id: r.ID, value: value, rawValue: rawValue, rawUnit: rawUnit, calibrationID: calibrationID})
+		}
+		if len(updates) == 0 {
+			continue
+		}
+		err = i.writer.Do(ctx, func(ctx context.Context, tx *storage.Tx) error {
+			for _, u := range updates {
+				if err := tx.UpdateCalibration(ctx, u.id, u.value, u.rawValue, u.rawUnit, u.calibrationID); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+		result.Updated += len(updates)
+	}
+}
+
+type calibrationUpdate struct {
+	id            int64
+	value         float64
+	rawValue      *float64
+	rawUnit       *string
+	calibrationID *int64
+}
@@ -9,9 +9,19 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sensor/cmd/api/calibration"
+	"sensor/cmd/api/collector"
 	"sensor/cmd/api/db"
+	"sensor/cmd/api/derive"
+	"sensor/cmd/api/handler"
+	"sensor/cmd/api/ingest"
+	"sensor/cmd/api/mqtt"
 	"sensor/cmd/api/settings"
 	"sensor/cmd/api/storage"
+	"sensor/cmd/api/throttle"
+	"sensor/cmd/api/udp"
+	"sensor/cmd/api/units"
+	"sensor/cmd/api/validation"
 	"syscall"
 	"time"
 
@@ -22,19 +32,46 @@ import (
 const version = "1.0.0"
 
 type config struct {
-	port int
-	db   string
-	env  string
+	port    int
+	udpPort int
+	db      string
+	env     string
+	writer  storage.WriterConfig
+	wsCreds string
+	mqtt    struct {
+		port        int
+		credentials string
+		url         string
+		clientID    string
+		username    string
+		password    string
+		topic       string
+		qos         int
+	}
 }
 
 type application struct {
-	config   config
-	infoLog  *log.Logger
-	errorLog *log.Logger
-	version  string
-	storage  *storage.SQLStorage
-	settings *settings.SettingsCache
-	cleaner  *storage.StorageCleaner
+	config      config
+	infoLog     *log.Logger
+	errorLog    *log.Logger
+	version     string
+	storage     *storage.SQLStorage
+	settings    *settings.SettingsCache
+	cleaner     *storage.StorageCleaner
+	writer      *storage.Writer
+	throttle    *throttle.StoreThrottle
+	broker      *handler.SSEBroker
+	ingestor    *ingest.Ingestor
+	subscriber  *mqtt.Subscriber
+	mqttBroker  *mqtt.Broker
+	udp         *udp.Listener
+	udpTracker  *udp.SequenceTracker
+	rules       *validation.Rules
+	units       *units.Registry
+	calibration *calibration.Profiles
+	formulas    *derive.Formulas
+	websocket   *handler.WebSocketHandler
+	collector   *collector.Scheduler
 }
 
 func (app *application) serve(ctx context.Context, shutdownTimeout time.Duration) error {
@@ -46,6 +83,7 @@ func (app *application) serve(ctx context.Context, shutdownTimeout time.Duration
 		ReadHeaderTimeout: 5 * time.Second,
 		WriteTimeout:      0, // for SSE/Long Pooling
 	}
+	srv.RegisterOnShutdown(app.websocket.Close)
 
 	serverErr := make(chan error, 1)
 	go func() {
@@ -58,6 +96,30 @@ func (app *application) serve(ctx context.Context, shutdownTimeout time.Duration
 
 	app.cleaner.StartCleanupJob(ctx, time.Minute*5)
 
+	if app.udp != nil {
+		if err := app.udp.Start(); err != nil {
+			return fmt.Errorf("start udp listener: %w", err)
+		}
+		defer app.udp.Close()
+	}
+
+	if app.mqttBroker != nil {
+		if err := app.mqttBroker.Start(); err != nil {
+			return fmt.Errorf("start mqtt broker: %w", err)
+		}
+		defer app.mqttBroker.Close()
+	}
+
+	if app.subscriber != nil {
+		if err := app.subscriber.Start(); err != nil {
+			return fmt.Errorf("start mqtt subscriber: %w", err)
+		}
+		defer app.subscriber.Close()
+	}
+
+	app.collector.Start()
+	defer app.collector.Close()
+
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
@@ -88,10 +150,27 @@ func (app *application) serve(ctx context.Context, shutdownTimeout time.Duration
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "import-csv" {
+		os.Exit(runImportCSV(os.Args[2:]))
+	}
+
 	var cfg config
 	flag.IntVar(&cfg.port, "port", 4001, "Server port to listen on")
+	flag.IntVar(&cfg.udpPort, "udp-port", 0, "UDP port for datagram ingestion (disabled when 0)")
+	flag.IntVar(&cfg.mqtt.port, "mqtt-port", 0, "Embedded MQTT broker port, e.g. 1883 (disabled when 0)")
+	flag.StringVar(&cfg.mqtt.credentials, "mqtt-credentials", "", "File with embedded MQTT broker device credentials, one username:password [sensor_id,...] per line; each account publishes only for its own sensors")
+	flag.StringVar(&cfg.wsCreds, "ws-credentials", "", "File with WebSocket device tokens, one sensor_id:token per line (any sensor may connect when empty)")
 	flag.StringVar(&cfg.env, "env", "development", "Application environment {development|production}")
 	flag.StringVar(&cfg.db, "db", "api.db", "The path to db file")
+	flag.IntVar(&cfg.writer.QueueSize, "write-queue", 1024, "Writes that may wait for the database writer before callers get 503")
+	flag.IntVar(&cfg.writer.BatchSize, "write-batch", 256, "Most writes committed in one transaction")
+	flag.DurationVar(&cfg.writer.Timeout, "write-timeout", 5*time.Second, "How long a write may wait to be queued and committed")
+	flag.StringVar(&cfg.mqtt.url, "mqtt-url", "", "MQTT broker to subscribe to, e.g. tcp://localhost:1883 (disabled when empty)")
+	flag.StringVar(&cfg.mqtt.clientID, "mqtt-client-id", "air-server", "MQTT client id")
+	flag.StringVar(&cfg.mqtt.username, "mqtt-username", "", "MQTT username")
+	flag.StringVar(&cfg.mqtt.password, "mqtt-password", "", "MQTT password")
+	flag.StringVar(&cfg.mqtt.topic, "mqtt-topic", "air/{sensor_id}/{measurement}", "MQTT topic pattern with {sensor_id} and optional {measurement}")
+	flag.IntVar(&cfg.mqtt.qos, "mqtt-qos", 1, "MQTT subscription QoS {0|1|2}")
 
 	flag.Parse()
 
@@ -119,17 +198,117 @@ func main() {
 	}
 
 	var settingsCache settings.SettingsCache
-	InitSettings(ctx, store, &settingsCache)
+	if err := InitSettings(ctx, store, &settingsCache, errorLog); err != nil {
+		errorLog.Println(err)
+		log.Fatal(err)
+	}
+
+	rules := validation.NewRules()
+	if err := InitValidationRules(ctx, store, rules); err != nil {
+		errorLog.Println(err)
+		log.Fatal(err)
+	}
+
+	unitRegistry := units.NewRegistry()
+	if err := InitUnits(ctx, store, unitRegistry); err != nil {
+		errorLog.Println(err)
+		log.Fatal(err)
+	}
+
+	profiles := calibration.NewProfiles()
+	if err := InitCalibration(ctx, store, profiles); err != nil {
+		errorLog.Println(err)
+		log.Fatal(err)
+	}
+
+	formulas := derive.NewFormulas()
+	if err := InitFormulas(ctx, store, formulas); err != nil {
+		errorLog.Println(err)
+		log.Fatal(err)
+	}
+
+	writer := storage.NewWriter(infoLog, errorLog, store, cfg.writer)
+	writer.Start()
+
+	storageCleaner := storage.NewStorageCleaner(writer, infoLog, errorLog, &settingsCache)
+	storeThrottle := throttle.NewStoreThrottle(&settingsCache)
+	broker := handler.NewSSEBroker(infoLog, errorLog)
+	go broker.Listen()
+	ingestor := ingest.NewIngestor(infoLog, errorLog, store, writer, &settingsCache, storeThrottle, throttle.NewAggregator(), rules, unitRegistry, profiles, formulas, broker)
+
+	var subscriber *mqtt.Subscriber
+	if cfg.mqtt.url != "" {
+		subscriber, err = mqtt.NewSubscriber(mqtt.SubscriberConfig{
+			BrokerURL: cfg.mqtt.url,
+			ClientID:  cfg.mqtt.clientID,
+			Username:  cfg.mqtt.username,
+			Password:  cfg.mqtt.password,
+			Topic:     cfg.mqtt.topic,
+			QoS:       byte(cfg.mqtt.qos),
+		}, ingestor, infoLog, errorLog)
+		if err != nil {
+			errorLog.Println(err)
+			log.Fatal(err)
+		}
+	}
+
+	var mqttBroker *mqtt.Broker
+	if cfg.mqtt.port != 0 {
+		mqttBroker, err = mqtt.NewBroker(mqtt.BrokerConfig{
+			Port:            cfg.mqtt.port,
+			Topic:           cfg.mqtt.topic,
+			CredentialsFile: cfg.mqtt.credentials,
+		}, ingestor, infoLog, errorLog)
+		if err != nil {
+			errorLog.Println(err)
+			log.Fatal(err)
+		}
+	}
+
+	udpTracker := udp.NewSequenceTracker()
+	var udpListener *udp.Listener
+	if cfg.udpPort != 0 {
+		udpListener = udp.NewListener(cfg.udpPort, ingestor, udpTracker, infoLog, errorLog)
+	}
+
+	var wsTokens map[string]string
+	if cfg.wsCreds != "" {
+		wsTokens, err = handler.LoadDeviceTokens(cfg.wsCreds)
+		if err != nil {
+			errorLog.Println(err)
+			log.Fatal(err)
+		}
+	}
+	websocketHandler := handler.NewWebSocketHandler(infoLog, errorLog, ingestor, wsTokens)
+
+	scheduler := collector.NewScheduler(infoLog, errorLog, ingestor)
+	if err := InitCollector(ctx, store, scheduler); err != nil {
+		errorLog.Println(err)
+		log.Fatal(err)
+	}
 
-	storageCleaner := storage.NewStorageCleaner(store, infoLog, errorLog, &settingsCache)
 	app := &application{
-		config:   cfg,
-		infoLog:  infoLog,
-		errorLog: errorLog,
-		version:  version,
-		storage:  store,
-		settings: &settingsCache,
-		cleaner:  storageCleaner,
+		config:      cfg,
+		infoLog:     infoLog,
+		errorLog:    errorLog,
+		version:     version,
+		storage:     store,
+		settings:    &settingsCache,
+		cleaner:     storageCleaner,
+		writer:      writer,
+		throttle:    storeThrottle,
+		broker:      broker,
+		ingestor:    ingestor,
+		subscriber:  subscriber,
+		mqttBroker:  mqttBroker,
+		udp:         udpListener,
+		udpTracker:  udpTracker,
+		rules:       rules,
+		units:       unitRegistry,
+		calibration: profiles,
+		formulas:    formulas,
+		websocket:   websocketHandler,
+		collector:   scheduler,
 	}
 
 	shutdownTimeout := time.Second * 3
@@ -138,6 +317,9 @@ func main() {
 		errorLog.Fatal(err)
 	}
 
+	// Ingestion has stopped, so the writer can commit what is queued.
+	writer.Close()
+
 	if err := database.Close(); err != nil {
 		errorLog.Printf("db close error: %v", err)
 	}
@@ -145,7 +327,10 @@ func main() {
 	infoLog.Println("Shutdown complete")
 }
 
-func InitSettings(ctx context.Context, storage *storage.SQLStorage, obj *settings.SettingsCache) error {
+// InitSettings loads every setting into obj, storing the defaults of
+// missing ones. A stored value that is not valid is logged and replaced by
+// its default, so one bad key cannot leave the others unset.
+func InitSettings(ctx context.Context, storage *storage.SQLStorage, obj *settings.SettingsCache, errorLog *log.Logger) error {
 	for key, defVal := range settings.DefaultSettings {
 		item, err := storage.GetSetting(ctx, key)
 		if err != nil {
@@ -160,23 +345,74 @@ func InitSettings(ctx context.Context, storage *storage.SQLStorage, obj *setting
 				return fmt.Errorf("upsert default %s: %w", key, err)
 			}
 		}
+		if err := settings.Validate(key, valStr); err != nil {
+			errorLog.Printf("Setting %s has invalid value %q, using default %q: %v", key, valStr, defVal, err)
+			valStr = defVal
+		}
 
 		switch key {
 		case settings.SettingKeyStoreInterval:
-			seconds, err := strconv.ParseFloat(valStr, 64)
-			if err != nil {
-				return fmt.Errorf("parse %s: %w", key, err)
-			}
+			seconds, _ := strconv.ParseFloat(valStr, 64)
 			obj.SetStoreInterval(time.Duration(seconds * float64(time.Second)))
 
 		case settings.SettingKeyMaxAge:
-			seconds, err := strconv.ParseFloat(valStr, 64)
-			if err != nil {
-				return fmt.Errorf("parse %s: %w", key, err)
-			}
+			seconds, _ := strconv.ParseFloat(valStr, 64)
 			obj.SetMaxAge(time.Duration(seconds * float64(time.Second)))
 
+		case settings.SettingKeyThrottleScope:
+			obj.SetThrottleScope(valStr)
+
+		case settings.SettingKeyStoreMode:
+			obj.SetStoreMode(valStr)
+
+		case settings.SettingKeyDedupMode:
+			obj.SetDedupMode(valStr)
+
 		}
 	}
 	return nil
 }
+
+func InitValidationRules(ctx context.Context, storage *storage.SQLStorage, rules *validation.Rules) error {
+	items, err := storage.GetValidationRules(ctx)
+	if err != nil {
+		return fmt.Errorf("get validation rules: %w", err)
+	}
+	rules.Load(items)
+	return nil
+}
+
+func InitUnits(ctx context.Context, storage *storage.SQLStorage, registry *units.Registry) error {
+	items, err := storage.GetCanonicalUnits(ctx)
+	if err != nil {
+		return fmt.Errorf("get canonical units: %w", err)
+	}
+	registry.Load(items)
+	return nil
+}
+
+func InitCalibration(ctx context.Context, storage *storage.SQLStorage, profiles *calibration.Profiles) error {
+	items, err := storage.GetCalibrationProfiles(ctx)
+	if err != nil {
+		return fmt.Errorf("get calibration profiles: %w", err)
+	}
+	profiles.Load(items)
+	return nil
+}
+
+func InitFormulas(ctx context.Context, storage *storage.SQLStorage, formulas *derive.Formulas) error {
+	items, err := storage.GetDerivedFormulas(ctx)
+	if err != nil {
+		return fmt.Errorf("get derived formulas: %w", err)
+	}
+	formulas.Load(items)
+	return nil
+}
+
+func InitCollector(ctx context.Context, storage *storage.SQLStorage, scheduler *collector.Scheduler) error {
+	targets, err := storage.GetCollectorTargets(ctx)
+	if err != nil {
+		return fmt.Errorf("get collector targets: %w", err)
+	}
+	return scheduler.Load(targets)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	// maxIngestBody caps single-reading requests after decompression.
+	maxIngestBody = 1 << 20
+	// maxBatchBody caps batch uploads (bulk, CSV, backfill, line protocol)
+	// after decompression, which also stops zip bombs.
+	maxBatchBody = 256 << 20
+)
+
+type decompressedBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (b *decompressedBody) Close() error {
+	var first error
+	for _, c := range b.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// decompressBody transparently decodes gzip and deflate request bodies and
+// limits the decoded body to limit bytes. Handlers see an *http.MaxBytesError
+// when the limit is exceeded and answer 413. Unknown encodings get 415.
+func (app *application) decompressBody(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+
+			var body io.ReadCloser
+			switch encoding {
+			case "", "identity":
+				body = r.Body
+
+			case "gzip", "x-gzip":
+				zr, err := gzip.NewReader(r.Body)
+				if err != nil {
+					app.errorLog.Printf("Bad gzip body %v", err)
+					http.Error(w, fmt.Sprintf("invalid gzip body: %v", err), http.StatusBadRequest)
+					return
+				}
+				body = &decompressedBody{Reader: zr, closers: []io.Closer{zr, r.Body}}
+
+			case "deflate":
+				fr, err := newDeflateReader(r.Body)
+				if err != nil {
+					app.errorLog.Printf("Bad deflate body %v", err)
+					http.Error(w, fmt.Sprintf("invalid deflate body: %v", err), http.StatusBadRequest)
+					return
+				}
+				body = &decompressedBody{Reader: fr, closers: []io.Closer{fr, r.Body}}
+
+			default:
+				app.errorLog.Printf("Unsupported Content-Encoding %q", encoding)
+				w.Header().Set("Accept-Encoding", "gzip, deflate")
+				http.Error(w, fmt.Sprintf("unsupported Content-Encoding %q", encoding), http.StatusUnsupportedMediaType)
+				return
+			}
+
+			if encoding != "" && encoding != "identity" {
+				r.Header.Del("Content-Encoding")
+				r.Header.Del("Content-Length")
+				r.ContentLength = -1
+			}
+			r.Body = http.MaxBytesReader(w, body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// newDeflateReader accepts the zlib-wrapped stream that HTTP specifies for
+// "deflate" as well as the raw DEFLATE stream some clients send instead.
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	isZlib := header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0
+	if isZlib {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
@@ -12,11 +12,28 @@ type MeasurementValue struct {
 	Parameter   *string `json:"parameter,omitempty"`
 	Value       float64 `json:"value"`
 	Unit        *string `json:"unit,omitempty"`
+	// Flag is set by validation for readings stored despite breaking a
+	// rule. It is never read from clients.
+	Flag *string `json:"-"`
+	// RawValue and RawUnit keep the reading as received when it was
+	// converted to the canonical unit or calibrated. They are never read
+	// from clients.
+	RawValue *float64 `json:"-"`
+	RawUnit  *string  `json:"-"`
+	// CalibrationID is the calibration profile applied to Value. It is
+	// never read from clients.
+	CalibrationID *int64 `json:"-"`
+	// Derived names the formula that computed the reading from other
+	// readings of the request. It is never read from clients.
+	Derived *string `json:"-"`
 }
 
 type CreateMeasurementReq struct {
 	SensorName string    `json:"sensor_name"`
 	Timestamp  time.Time `json:"timestamp,omitempty"`
+	// MessageID identifies the reading so that a retried delivery is
+	// stored only once.
+	MessageID *string `json:"message_id,omitempty"`
 	// Single measurement
 	Measurement *string `json:"measurement,omitempty"`
 	Parameter   *string `json:"parameter,omitempty"`
@@ -54,3 +71,62 @@ func (r *CreateMeasurementReq) ExtractValues() ([]MeasurementValue, error) {
 		},
 	}, nil
 }
+
+// ValidateTimestamp checks that a timestamp, when present, is neither
+// older than oldest nor later than latest.
+func (r *CreateMeasurementReq) ValidateTimestamp(oldest, latest time.Time) error {
+	if r.Timestamp.IsZero() {
+		return nil
+	}
+	if r.Timestamp.Before(oldest) {
+		return fmt.Errorf("%w: timestamp is older than max_age", ErrBadPayload)
+	}
+	if r.Timestamp.After(latest) {
+		return fmt.Errorf("%w: timestamp is in the future", ErrBadPayload)
+	}
+	return nil
+}
+
+// BackfillMeasurementReq carries historical readings that a device buffered
+// while it was offline. Each reading must have its own timestamp.
+type BackfillMeasurementReq struct {
+	SensorName string                 `json:"sensor_name"`
+	Readings   []CreateMeasurementReq `json:"readings"`
+}
+
+// Validate checks that every reading is timestamped and is not older than
+// oldest, because such rows would be removed by the next cleanup anyway.
+func (r *BackfillMeasurementReq) Validate(oldest time.Time) error {
+	if len(r.Readings) == 0 {
+		return fmt.Errorf("%w: readings are required", ErrBadPayload)
+	}
+	for i, reading := range r.Readings {
+		if reading.Timestamp.IsZero() {
+			return fmt.Errorf("%w: readings[%d].timestamp is required", ErrBadPayload, i)
+		}
+		if reading.Timestamp.Before(oldest) {
+			return fmt.Errorf("%w: readings[%d].timestamp is older than max_age", ErrBadPayload, i)
+		}
+	}
+	return nil
+}
+
+// BulkMeasurementLine is one line of an NDJSON bulk upload. It is a regular
+// create request that also names its sensor, so one upload can carry the
+// readings of many sensors.
+type BulkMeasurementLine struct {
+	SensorID string `json:"sensor_id"`
+	CreateMeasurementReq
+}
+
+// Validate checks the sensor id and that a timestamp, when present, is not
+// older than oldest.
+func (l *BulkMeasurementLine) Validate(oldest time.Time) error {
+	if len(l.SensorID) == 0 {
+		return fmt.Errorf("%w: sensor_id is required", ErrBadPayload)
+	}
+	if !l.Timestamp.IsZero() && l.Timestamp.Before(oldest) {
+		return fmt.Errorf("%w: timestamp is older than max_age", ErrBadPayload)
+	}
+	return nil
+}
@@ -12,5 +12,6 @@ type MeasurementSSE struct {
 	Parameter   *string   `json:"parameter,omitempty"`
 	Value       float64   `json:"value"`
 	Unit        *string   `json:"unit,omitempty"`
+	Derived     *string   `json:"derived,omitempty"`
 	Timestamp   time.Time `json:"timestamp"`
 }
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SensorCommunityReq is the JSON pushed by the airrohr firmware to a
+// Sensor.Community (formerly Luftdaten) compatible "custom API".
+type SensorCommunityReq struct {
+	ESP8266ID        string                     `json:"esp8266id"`
+	SoftwareVersion  string                     `json:"software_version"`
+	SensorDataValues []SensorCommunityDataValue `json:"sensordatavalues"`
+}
+
+type SensorCommunityDataValue struct {
+	ValueType string               `json:"value_type"`
+	Value     SensorCommunityValue `json:"value"`
+}
+
+// SensorCommunityValue accepts both quoted ("12.30") and plain numbers, as
+// firmware versions differ.
+type SensorCommunityValue float64
+
+func (v *SensorCommunityValue) UnmarshalJSON(b []byte) error {
+	s := string(bytes.Trim(b, `"`))
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return fmt.Errorf("value %s is not a number", b)
+	}
+	*v = SensorCommunityValue(f)
+	return nil
+}
+
+type sensorCommunityType struct {
+	measurement string
+	unit        string
+}
+
+// sensorCommunityTypes maps the part of value_type after the sensor prefix,
+// e.g. P2 in SDS_P2, to a measurement. Types not listed, such as the
+// diagnostic samples, min_micro and max_micro, are ignored.
+var sensorCommunityTypes = map[string]sensorCommunityType{
+	"P0":           {measurement: "pm1", unit: "µg/m3"},
+	"P1":           {measurement: "pm10", unit: "µg/m3"},
+	"P2":           {measurement: "pm25", unit: "µg/m3"},
+	"P4":           {measurement: "pm4", unit: "µg/m3"},
+	"temperature":  {measurement: "temperature", unit: "°C"},
+	"humidity":     {measurement: "humidity", unit: "%"},
+	"pressure":     {measurement: "pressure", unit: "Pa"},
+	"co2":          {measurement: "co2", unit: "ppm"},
+	"noise_LAeq":   {measurement: "noise_laeq", unit: "dB(A)"},
+	"noise_LA_min": {measurement: "noise_la_min", unit: "dB(A)"},
+	"noise_LA_max": {measurement: "noise_la_max", unit: "dB(A)"},
+	"signal":       {measurement: "wifi_signal", unit: "dBm"},
+}
+
+// SensorID returns the id the firmware uses on sensor.community, which is
+// also what it sends in the X-Sensor header.
+func (r *SensorCommunityReq) SensorID() string {
+	if len(r.ESP8266ID) == 0 {
+		return ""
+	}
+	return "esp8266-" + r.ESP8266ID
+}
+
+// sensorCommunityTypeOf looks up a value type as a whole, since some kinds
+// such as noise_LAeq contain an underscore themselves, and otherwise after
+// stripping a sensor model prefix, which is returned along with the type.
+func sensorCommunityTypeOf(valueType string) (sensorCommunityType, string, bool) {
+	if t, ok := sensorCommunityTypes[valueType]; ok {
+		return t, "", true
+	}
+	prefix, kind, found := strings.Cut(valueType, "_")
+	if !found {
+		return sensorCommunityType{}, "", false
+	}
+	t, ok := sensorCommunityTypes[kind]
+	return t, prefix, ok
+}
+
+// ToCreateMeasurementReq converts the known value types. The sensor model
+// prefix of a value type (SDS, BME280, ...) becomes the parameter. Unknown
+// value types are returned so they can be reported.
+func (r *SensorCommunityReq) ToCreateMeasurementReq(sensorName string) (CreateMeasurementReq, []string) {
+	req := CreateMeasurementReq{SensorName: sensorName}
+	var ignored []string
+	for _, dv := range r.SensorDataValues {
+		t, prefix, ok := sensorCommunityTypeOf(dv.ValueType)
+		if !ok {
+			ignored = append(ignored, dv.ValueType)
+			continue
+		}
+		v := MeasurementValue{Measurement: t.measurement, Value: float64(dv.Value)}
+		unit := t.unit
+		v.Unit = &unit
+		if len(prefix) != 0 {
+			parameter := prefix
+			v.Parameter = &parameter
+		}
+		req.Measurements = append(req.Measurements, v)
+	}
+	return req, ignored
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSensorCommunityDNMSNoise(t *testing.T) {
+	body := `{"esp8266id":"1234","software_version":"NRZ-2020-133","sensordatavalues":[
+		{"value_type":"noise_LAeq","value":"48.21"},
+		{"value_type":"noise_LA_min","value":"41.02"},
+		{"value_type":"noise_LA_max","value":"63.75"},
+		{"value_type":"SDS_P1","value":"12.30"},
+		{"value_type":"BME280_temperature","value":"21.5"},
+		{"value_type":"samples","value":"880000"}
+	]}`
+	var payload SensorCommunityReq
+	if err := json.Unmarshal([]byte(body), &payload); err != nil {
+		t.Fatal(err)
+	}
+
+	req, ignored := payload.ToCreateMeasurementReq("node")
+	if len(ignored) != 1 || ignored[0] != "samples" {
+		t.Errorf("ignored = %v, want [samples]", ignored)
+	}
+
+	want := []struct {
+		measurement string
+		parameter   string
+		value       float64
+	}{
+		{"noise_laeq", "", 48.21},
+		{"noise_la_min", "", 41.02},
+		{"noise_la_max", "", 63.75},
+		{"pm10", "SDS", 12.30},
+		{"temperature", "BME280", 21.5},
+	}
+	if len(req.Measurements) != len(want) {
+		t.Fatalf("got %d measurements, want %d", len(req.Measurements), len(want))
+	}
+	for n, w := range want {
+		got := req.Measurements[n]
+		parameter := ""
+		if got.Parameter != nil {
+			parameter = *got.Parameter
+		}
+		if got.Measurement != w.measurement || parameter != w.parameter || got.Value != w.value {
+			t.Errorf("measurements[%d] = %s/%s %v, want %s/%s %v", n, got.Measurement, parameter, got.Value, w.measurement, w.parameter, w.value)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package mqtt
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"sensor/cmd/api/ingest"
+	"strings"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+type BrokerConfig struct {
+	Port            int
+	Topic           string
+	CredentialsFile string
+}
+
+// Broker is an embedded MQTT 3.1.1/5 broker. Devices connect to it directly
+// and their publishes matching the topic pattern are ingested in-process.
+// Retained messages are kept by the broker, so a new subscriber immediately
+// receives the last value of every retained topic.
+type Broker struct {
+	config   BrokerConfig
+	handler  *messageHandler
+	server   *mochi.Server
+	infoLog  *log.Logger
+	errorLog *log.Logger
+}
+
+func NewBroker(config BrokerConfig, ingestor *ingest.Ingestor, infoLog *log.Logger, errorLog *log.Logger) (*Broker, error) {
+	pattern, err := ParseTopicPattern(config.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	server := mochi.New(&mochi.Options{
+		InlineClient: true,
+		Logger:       slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelWarn})),
+	})
+
+	if config.CredentialsFile != "" {
+		ledger, err := loadCredentials(config.CredentialsFile, pattern)
+		if err != nil {
+			return nil, err
+		}
+		if err := server.AddHook(new(auth.Hook), &auth.Options{Ledger: ledger}); err != nil {
+			return nil, fmt.Errorf("add mqtt auth hook: %w", err)
+		}
+	} else {
+		if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+			return nil, fmt.Errorf("add mqtt allow hook: %w", err)
+		}
+	}
+
+	tcp := listeners.NewTCP(listeners.Config{ID: "tcp", Address: fmt.Sprintf(":%d", config.Port)})
+	if err := server.AddListener(tcp); err != nil {
+		return nil, fmt.Errorf("add mqtt listener: %w", err)
+	}
+
+	return &Broker{
+		config:   config,
+		handler:  &messageHandler{pattern: pattern, ingestor: ingestor},
+		server:   server,
+		infoLog:  infoLog,
+		errorLog: errorLog,
+	}, nil
+}
+
+func (b *Broker) Start() error {
+	filter := b.handler.pattern.Filter()
+	if err := b.server.Subscribe(filter, 1, b.onMessage); err != nil {
+		return fmt.Errorf("subscribe %s: %w", filter, err)
+	}
+	if err := b.server.Serve(); err != nil {
+		return err
+	}
+	b.infoLog.Printf("Embedded MQTT broker listening on :%d, ingesting %s", b.config.Port, filter)
+	return nil
+}
+
+func (b *Broker) Close() {
+	if err := b.server.Close(); err != nil {
+		b.errorLog.Printf("MQTT broker close error %v", err)
+		return
+	}
+	b.infoLog.Println("Embedded MQTT broker stopped")
+}
+
+func (b *Broker) onMessage(cl *mochi.Client, _ packets.Subscription, pk packets.Packet) {
+	if err := b.handler.handle(pk.TopicName, pk.Payload); err != nil {
+		b.errorLog.Printf("MQTT message from %s on %s rejected %v", cl.ID, pk.TopicName, err)
+	}
+}
+
+// loadCredentials reads device credentials, one "username:password" per
+// line, optionally followed by whitespace and a comma separated list of the
+// sensor ids the device publishes for; "*" allows any sensor. Without a
+// list the username is the sensor id. Empty lines and lines starting with
+// '#' are ignored. Authenticated devices may subscribe to any topic but
+// only publish to the topics of their own sensors.
+func loadCredentials(path string, pattern TopicPattern) (*auth.Ledger, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open mqtt credentials: %w", err)
+	}
+	defer f.Close()
+
+	ledger := &auth.Ledger{
+		Users: auth.Users{},
+		// Topics not granted to a user below can be read but not written.
+		ACL: auth.ACLRules{{Filters: auth.Filters{"#": auth.ReadOnly}}},
+	}
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		username, password, ok := strings.Cut(fields[0], ":")
+		if !ok || username == "" || password == "" || len(fields) > 2 {
+			return nil, fmt.Errorf("mqtt credentials line %d: expected username:password [sensor_id,...]", lineNo)
+		}
+		sensorIDs := []string{username}
+		if len(fields) == 2 {
+			sensorIDs = strings.Split(fields[1], ",")
+		}
+
+		acl := auth.Filters{}
+		for _, sensorID := range sensorIDs {
+			switch {
+			case sensorID == "*":
+				acl["#"] = auth.ReadWrite
+			case sensorID == "" || strings.ContainsAny(sensorID, "+#/"):
+				return nil, fmt.Errorf("mqtt credentials line %d: bad sensor id %q", lineNo, sensorID)
+			default:
+				acl[auth.RString(pattern.SensorFilter(sensorID))] = auth.ReadWrite
+			}
+		}
+		ledger.Users[username] = auth.UserRule{
+			Username: auth.RString(username),
+			Password: auth.RString(password),
+			ACL:      acl,
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read mqtt credentials: %w", err)
+	}
+	return ledger, nil
+}
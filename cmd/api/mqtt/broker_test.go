@@ -0,0 +1,65 @@
+package mqtt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+)
+
+func TestCredentialsLimitPublishesToOwnSensors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.txt")
+	creds := "kitchen:secret\ngateway:secret hall,attic\nbridge:secret *\n"
+	if err := os.WriteFile(path, []byte(creds), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pattern, err := ParseTopicPattern("air/{sensor_id}/{measurement}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger, err := loadCredentials(path, pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		username string
+		topic    string
+		write    bool
+		ok       bool
+	}{
+		{"kitchen", "air/kitchen/pm25", true, true},
+		{"kitchen", "air/hall/pm25", true, false},
+		{"kitchen", "status/kitchen", true, false},
+		{"kitchen", "air/hall/pm25", false, true},
+		{"gateway", "air/hall/pm25", true, true},
+		{"gateway", "air/attic/temperature", true, true},
+		{"gateway", "air/gateway/pm25", true, false},
+		{"bridge", "air/anything/pm25", true, true},
+	}
+	for _, tt := range tests {
+		cl := &mochi.Client{ID: tt.username}
+		cl.Properties.Username = []byte(tt.username)
+		if _, ok := ledger.ACLOk(cl, tt.topic, tt.write); ok != tt.ok {
+			t.Errorf("%s write=%v %s = %v, want %v", tt.username, tt.write, tt.topic, ok, tt.ok)
+		}
+	}
+}
+
+func TestSensorFilter(t *testing.T) {
+	tests := []struct{ pattern, want string }{
+		{"air/{sensor_id}/{measurement}", "air/kitchen/#"},
+		{"{measurement}/{sensor_id}", "+/kitchen"},
+		{"sensors/{sensor_id}", "sensors/kitchen"},
+	}
+	for _, tt := range tests {
+		p, err := ParseTopicPattern(tt.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.SensorFilter("kitchen"); got != tt.want {
+			t.Errorf("SensorFilter(%s) = %s, want %s", tt.pattern, got, tt.want)
+		}
+	}
+}
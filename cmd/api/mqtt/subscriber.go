@@ -0,0 +1,149 @@
+// Package mqtt ingests readings published by devices over MQTT
+package mqtt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sensor/cmd/api/ingest"
+	"sensor/cmd/api/models"
+	"strconv"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+type SubscriberConfig struct {
+	BrokerURL string
+	ClientID  string
+	Username  string
+	Password  string
+	Topic     string
+	QoS       byte
+}
+
+// Subscriber connects to an MQTT broker as a client and feeds every message
+// matching the topic pattern into the ingestor.
+type Subscriber struct {
+	config   SubscriberConfig
+	handler  *messageHandler
+	client   paho.Client
+	infoLog  *log.Logger
+	errorLog *log.Logger
+}
+
+func NewSubscriber(config SubscriberConfig, ingestor *ingest.Ingestor, infoLog *log.Logger, errorLog *log.Logger) (*Subscriber, error) {
+	pattern, err := ParseTopicPattern(config.Topic)
+	if err != nil {
+		return nil, err
+	}
+	if config.QoS > 2 {
+		return nil, fmt.Errorf("invalid mqtt qos %d", config.QoS)
+	}
+	return &Subscriber{
+		config:   config,
+		handler:  &messageHandler{pattern: pattern, ingestor: ingestor},
+		infoLog:  infoLog,
+		errorLog: errorLog,
+	}, nil
+}
+
+// Start connects to the broker. Subscriptions are (re)established on every
+// successful connection, so the subscriber survives broker restarts.
+func (s *Subscriber) Start() error {
+	opts := paho.NewClientOptions().
+		AddBroker(s.config.BrokerURL).
+		SetClientID(s.config.ClientID).
+		SetUsername(s.config.Username).
+		SetPassword(s.config.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(5 * time.Second).
+		SetOrderMatters(false).
+		SetOnConnectHandler(s.onConnect).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			s.errorLog.Printf("MQTT connection lost %v", err)
+		})
+
+	s.client = paho.NewClient(opts)
+	token := s.client.Connect()
+	if !token.WaitTimeout(10 * time.Second) {
+		s.infoLog.Printf("MQTT broker %s not reachable yet, retrying in background", s.config.BrokerURL)
+		return nil
+	}
+	return token.Error()
+}
+
+func (s *Subscriber) Close() {
+	if s.client == nil {
+		return
+	}
+	s.client.Disconnect(250)
+	s.infoLog.Println("MQTT subscriber disconnected")
+}
+
+func (s *Subscriber) onConnect(client paho.Client) {
+	filter := s.handler.pattern.Filter()
+	token := client.Subscribe(filter, s.config.QoS, s.onMessage)
+	if token.Wait() && token.Error() != nil {
+		s.errorLog.Printf("MQTT subscribe %s failed %v", filter, token.Error())
+		return
+	}
+	s.infoLog.Printf("MQTT subscribed to %s on %s", filter, s.config.BrokerURL)
+}
+
+func (s *Subscriber) onMessage(_ paho.Client, msg paho.Message) {
+	// Retained messages are replayed on every (re)subscribe and were
+	// already ingested when they were first published.
+	if msg.Retained() {
+		return
+	}
+	if err := s.handler.handle(msg.Topic(), msg.Payload()); err != nil {
+		s.errorLog.Printf("MQTT message on %s rejected %v", msg.Topic(), err)
+	}
+}
+
+// messageHandler turns MQTT publishes into ingested readings. It is shared
+// by the external subscriber and the embedded broker.
+type messageHandler struct {
+	pattern  TopicPattern
+	ingestor *ingest.Ingestor
+}
+
+func (h *messageHandler) handle(topic string, payload []byte) error {
+	sensorID, measurement, ok := h.pattern.Match(topic)
+	if !ok {
+		return fmt.Errorf("topic does not match pattern")
+	}
+	req, err := DecodePayload(payload, measurement)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = h.ingestor.Ingest(ctx, sensorID, &req)
+	return err
+}
+
+// DecodePayload parses a message in the shape of CreateMeasurementReq. A
+// bare number is accepted as the value of the measurement named by the
+// topic. A measurement taken from the topic fills in a single-value payload
+// that does not name one itself.
+func DecodePayload(payload []byte, measurement string) (models.CreateMeasurementReq, error) {
+	var req models.CreateMeasurementReq
+	trimmed := bytes.TrimSpace(payload)
+
+	if value, err := strconv.ParseFloat(string(trimmed), 64); err == nil {
+		req.Value = value
+	} else if err := json.Unmarshal(trimmed, &req); err != nil {
+		return req, fmt.Errorf("%w: %v", models.ErrBadPayload, err)
+	}
+
+	if len(measurement) != 0 && req.Measurement == nil && len(req.Measurements) == 0 {
+		req.Measurement = &measurement
+	}
+	return req, nil
+}
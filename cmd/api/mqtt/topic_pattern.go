@@ -0,0 +1,102 @@
+package mqtt
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	placeholderSensorID    = "{sensor_id}"
+	placeholderMeasurement = "{measurement}"
+)
+
+// TopicPattern maps MQTT topics such as air/{sensor_id}/{measurement} onto
+// a sensor id and an optional measurement name.
+type TopicPattern struct {
+	segments []string
+}
+
+func ParseTopicPattern(pattern string) (TopicPattern, error) {
+	segments := strings.Split(pattern, "/")
+	hasSensorID := false
+	for _, s := range segments {
+		switch s {
+		case placeholderSensorID:
+			if hasSensorID {
+				return TopicPattern{}, fmt.Errorf("topic pattern %q has more than one %s", pattern, placeholderSensorID)
+			}
+			hasSensorID = true
+		case placeholderMeasurement, "+":
+		default:
+			if strings.ContainsAny(s, "+#{}") {
+				return TopicPattern{}, fmt.Errorf("topic pattern %q has unsupported segment %q", pattern, s)
+			}
+		}
+	}
+	if !hasSensorID {
+		return TopicPattern{}, fmt.Errorf("topic pattern %q must contain %s", pattern, placeholderSensorID)
+	}
+	return TopicPattern{segments: segments}, nil
+}
+
+// Filter returns the subscription filter with placeholders replaced by '+'.
+func (p TopicPattern) Filter() string {
+	out := make([]string, len(p.segments))
+	for i, s := range p.segments {
+		switch s {
+		case placeholderSensorID, placeholderMeasurement:
+			out[i] = "+"
+		default:
+			out[i] = s
+		}
+	}
+	return strings.Join(out, "/")
+}
+
+// Match extracts the sensor id and measurement from a topic. The measurement
+// is empty when the pattern has no {measurement} segment.
+func (p TopicPattern) Match(topic string) (sensorID string, measurement string, ok bool) {
+	parts := strings.Split(topic, "/")
+	if len(parts) != len(p.segments) {
+		return "", "", false
+	}
+	for i, s := range p.segments {
+		switch s {
+		case placeholderSensorID:
+			sensorID = parts[i]
+		case placeholderMeasurement:
+			measurement = parts[i]
+		case "+":
+		default:
+			if parts[i] != s {
+				return "", "", false
+			}
+		}
+	}
+	if len(sensorID) == 0 {
+		return "", "", false
+	}
+	return sensorID, measurement, true
+}
+
+// SensorFilter returns the filter of the topics of one sensor: the pattern
+// up to its {sensor_id} segment, which is sensorID, followed by '#' when
+// more segments follow.
+func (p TopicPattern) SensorFilter(sensorID string) string {
+	var out []string
+	for i, s := range p.segments {
+		switch s {
+		case placeholderSensorID:
+			out = append(out, sensorID)
+			if i < len(p.segments)-1 {
+				out = append(out, "#")
+			}
+			return strings.Join(out, "/")
+		case placeholderMeasurement:
+			out = append(out, "+")
+		default:
+			out = append(out, s)
+		}
+	}
+	return strings.Join(out, "/")
+}
@@ -8,9 +8,13 @@ import (
 	"time"
 )
 
+// MeasurementCursor points after the last record of a page. Pages of a
+// time range are ordered by reading time and carry Timestamp; other pages
+// are ordered by CreatedAt.
 type MeasurementCursor struct {
-	CreatedAt time.Time `json:"created_at"`
-	ID        int64     `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	ID        int64      `json:"id"`
 }
 
 func Encode(c MeasurementCursor) string {
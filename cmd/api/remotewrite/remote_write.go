@@ -0,0 +1,172 @@
+// Package remotewrite decodes Prometheus remote_write requests
+package remotewrite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var ErrBadRequest = errors.New("invalid remote write request")
+
+// Field numbers of prometheus.WriteRequest (remote write 1.0). The
+// messages are decoded directly from the wire format, like the
+// measurement protobuf in package codec.
+const (
+	writeTimeseries = 1
+
+	seriesLabels  = 1
+	seriesSamples = 2
+
+	labelName  = 1
+	labelValue = 2
+
+	sampleValue     = 1
+	sampleTimestamp = 2
+)
+
+// staleNaN is the value Prometheus writes to mark a series as stale. It is
+// not a reading.
+const staleNaN uint64 = 0x7ff0000000000002
+
+type Sample struct {
+	Value     float64
+	Timestamp time.Time
+}
+
+// Series is one time series of a request: its labels, including the
+// metric name in "__name__", and its samples.
+type Series struct {
+	Labels  map[string]string
+	Samples []Sample
+}
+
+// Decode decompresses a snappy block encoded WriteRequest of at most
+// maxSize bytes and returns its series. Staleness markers are dropped.
+func Decode(body []byte, maxSize int) ([]Series, error) {
+	n, err := snappy.DecodedLen(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadRequest, err)
+	}
+	if n > maxSize {
+		return nil, fmt.Errorf("%w: decompressed size %d exceeds %d bytes", ErrBadRequest, n, maxSize)
+	}
+	b, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadRequest, err)
+	}
+
+	var out []Series
+	err = walk(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num != writeTimeseries || typ != protowire.BytesType {
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+		msg, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return n, nil
+		}
+		s, err := decodeSeries(msg)
+		if err != nil {
+			return 0, fmt.Errorf("timeseries[%d]: %w", len(out), err)
+		}
+		out = append(out, s)
+		return n, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadRequest, err)
+	}
+	return out, nil
+}
+
+func decodeSeries(b []byte) (Series, error) {
+	s := Series{Labels: make(map[string]string)}
+	err := walk(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if typ != protowire.BytesType || (num != seriesLabels && num != seriesSamples) {
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+		msg, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return n, nil
+		}
+		if num == seriesLabels {
+			name, value, err := decodeLabel(msg)
+			if err != nil {
+				return 0, err
+			}
+			s.Labels[name] = value
+			return n, nil
+		}
+		sample, stale, err := decodeSample(msg)
+		if err != nil {
+			return 0, err
+		}
+		if !stale {
+			s.Samples = append(s.Samples, sample)
+		}
+		return n, nil
+	})
+	return s, err
+}
+
+func decodeLabel(b []byte) (string, string, error) {
+	var name, value string
+	err := walk(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == labelName && typ == protowire.BytesType:
+			s, n := protowire.ConsumeString(b)
+			name = s
+			return n, nil
+		case num == labelValue && typ == protowire.BytesType:
+			s, n := protowire.ConsumeString(b)
+			value = s
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+	return name, value, err
+}
+
+func decodeSample(b []byte) (Sample, bool, error) {
+	var bits uint64
+	var ms int64
+	err := walk(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == sampleValue && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			bits = v
+			return n, nil
+		case num == sampleTimestamp && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			ms = int64(v)
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+	sample := Sample{Value: math.Float64frombits(bits), Timestamp: time.UnixMilli(ms).UTC()}
+	return sample, bits == staleNaN, err
+}
+
+// walk calls field for every field of a message. field consumes the value
+// and returns its length, or a negative protowire error code.
+func walk(b []byte, field func(num protowire.Number, typ protowire.Type, b []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		n, err := field(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return fmt.Errorf("field %d: %w", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+	return nil
+}
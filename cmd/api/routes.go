@@ -10,22 +10,96 @@ import (
 func (app *application) routes() http.Handler {
 	mux := chi.NewRouter()
 
-	measurementHandler := handler.NewMeasurementHandler(app.infoLog, app.errorLog, app.storage, app.settings)
+	measurementHandler := handler.NewMeasurementHandler(app.infoLog, app.errorLog, app.storage, app.broker, app.ingestor)
 	slowHandler := handler.NewSlowHandler(app.infoLog)
 	settingsHandler := handler.NewSettingsHandler(app.infoLog, app.errorLog, app.storage, app.settings)
 	sensorsHandler := handler.NewSensorHandler(app.infoLog, app.errorLog, app.storage)
+	throttleHandler := handler.NewThrottleHandler(app.infoLog, app.errorLog, app.throttle)
+	influxHandler := handler.NewInfluxHandler(app.infoLog, app.errorLog, app.ingestor)
+	prometheusHandler := handler.NewPrometheusHandler(app.infoLog, app.errorLog, app.ingestor)
+	bulkHandler := handler.NewBulkHandler(app.infoLog, app.errorLog, app.ingestor)
+	csvHandler := handler.NewCSVHandler(app.infoLog, app.errorLog, app.ingestor)
+	udpHandler := handler.NewUDPHandler(app.infoLog, app.errorLog, app.udpTracker)
+	sensorCommunityHandler := handler.NewSensorCommunityHandler(app.infoLog, app.errorLog, app.ingestor)
+	validationHandler := handler.NewValidationHandler(app.infoLog, app.errorLog, app.storage, app.rules)
+	quarantineHandler := handler.NewQuarantineHandler(app.infoLog, app.errorLog, app.storage, app.ingestor)
+	unitsHandler := handler.NewUnitsHandler(app.infoLog, app.errorLog, app.storage, app.units)
+	calibrationHandler := handler.NewCalibrationHandler(app.infoLog, app.errorLog, app.storage, app.calibration, app.ingestor)
+	dedupHandler := handler.NewDedupHandler(app.infoLog, app.errorLog, app.ingestor.Dedup())
+	writerHandler := handler.NewWriterHandler(app.infoLog, app.errorLog, app.writer)
+	formulaHandler := handler.NewFormulaHandler(app.infoLog, app.errorLog, app.storage, app.formulas)
+	collectorHandler := handler.NewCollectorHandler(app.infoLog, app.errorLog, app.storage, app.collector)
+	idempotent := handler.NewIdempotency(app.infoLog, app.errorLog, app.storage, app.writer, app.ingestor.Dedup()).Middleware
 
 	mux.Get("/health", handler.HealthCheck)
 	mux.Get("/slow", slowHandler.MakeItSlow)
 	mux.Get("/slow/{seconds}", slowHandler.MakeItSlow)
+
+	ingestBody := app.decompressBody(maxIngestBody)
+	batchBody := app.decompressBody(maxBatchBody)
+
 	mux.Route("/api/measurements", func(r chi.Router) {
+		r.With(batchBody, idempotent).Post("/bulk", bulkHandler.Create)
+		r.With(batchBody, idempotent).Post("/import/csv", csvHandler.Import)
 		r.Get("/{sensor_id}", measurementHandler.Get)
-		r.Post("/{sensor_id}", measurementHandler.Create)
+		r.With(ingestBody, idempotent).Post("/{sensor_id}", measurementHandler.Create)
+		r.With(batchBody, idempotent).Post("/{sensor_id}/backfill", measurementHandler.Backfill)
 		r.Get("/{sensor_id}/stream", measurementHandler.Stream)
 	})
+	mux.With(batchBody, idempotent).Post("/api/v2/write", influxHandler.Write)
+	// Remote write bodies are snappy compressed and marked with
+	// Content-Encoding: snappy, so they skip decompressBody.
+	mux.Post("/api/prom/write", prometheusHandler.Write)
+	mux.With(ingestBody, idempotent).Post("/api/push/sensor-community", sensorCommunityHandler.Push)
+	mux.Get("/api/ws", app.websocket.Serve)
 	mux.Route("/api/sensors", func(r chi.Router) {
 		r.Get("/", sensorsHandler.Get)
 	})
+	mux.Route("/api/throttle", func(r chi.Router) {
+		r.Get("/", throttleHandler.Get)
+		r.Get("/{sensor_id}", throttleHandler.Get)
+	})
+	mux.Get("/api/udp/stats", udpHandler.Stats)
+	mux.Get("/api/dedup/stats", dedupHandler.Stats)
+	mux.Get("/api/writer/stats", writerHandler.Stats)
+	mux.Route("/api/validation/rules", func(r chi.Router) {
+		r.Get("/", validationHandler.ListRules)
+		r.Get("/{measurement}", validationHandler.GetRule)
+		r.Post("/{measurement}", validationHandler.UpdateRule)
+		r.Delete("/{measurement}", validationHandler.DeleteRule)
+	})
+	mux.Route("/api/units", func(r chi.Router) {
+		r.Get("/", unitsHandler.List)
+		r.Get("/{measurement}", unitsHandler.Get)
+		r.Post("/{measurement}", unitsHandler.Update)
+		r.Delete("/{measurement}", unitsHandler.Delete)
+	})
+	mux.Route("/api/derived", func(r chi.Router) {
+		r.Get("/", formulaHandler.List)
+		r.Get("/{measurement}", formulaHandler.Get)
+		r.Post("/{measurement}", formulaHandler.Update)
+		r.Delete("/{measurement}", formulaHandler.Delete)
+	})
+	mux.Route("/api/calibration/{sensor_id}", func(r chi.Router) {
+		r.Get("/", calibrationHandler.List)
+		r.Get("/{measurement}", calibrationHandler.List)
+		r.Post("/{measurement}", calibrationHandler.Create)
+		r.Delete("/{measurement}/{id}", calibrationHandler.Delete)
+		r.Post("/{measurement}/recalibrate", calibrationHandler.Recalibrate)
+	})
+	mux.Route("/api/collector/targets", func(r chi.Router) {
+		r.Get("/", collectorHandler.List)
+		r.Post("/", collectorHandler.Create)
+		r.Get("/{id}", collectorHandler.Get)
+		r.Delete("/{id}", collectorHandler.Delete)
+		r.Post("/{id}/poll", collectorHandler.Poll)
+	})
+	mux.Route("/api/quarantine", func(r chi.Router) {
+		r.Get("/", quarantineHandler.List)
+		r.Delete("/", quarantineHandler.Purge)
+		r.Post("/{id}/release", quarantineHandler.Release)
+		r.Delete("/{id}", quarantineHandler.Delete)
+	})
 	mux.Route("/api/settings", func(r chi.Router) {
 		r.Get("/", settingsHandler.ListSettings)
 		r.Get("/{key}", settingsHandler.GetSetting)
@@ -1,12 +1,71 @@
 // Package settings contains defaults
 package settings
 
+import (
+	"fmt"
+	"strconv"
+)
+
 const (
 	SettingKeyMaxAge        = "max_age"
 	SettingKeyStoreInterval = "store_interval"
+	SettingKeyThrottleScope = "throttle_scope"
+	SettingKeyStoreMode     = "store_mode"
+	SettingKeyDedupMode     = "dedup_mode"
+)
+
+const (
+	ThrottleScopeSensor      = "sensor"
+	ThrottleScopeMeasurement = "measurement"
+)
+
+const (
+	StoreModeSample    = "sample"
+	StoreModeAggregate = "aggregate"
+)
+
+const (
+	// DedupModeMessageID only drops readings that repeat a message_id.
+	DedupModeMessageID = "message_id"
+	// DedupModeTimestamp also treats readings with the same sensor_id,
+	// measurement, parameter and timestamp as duplicates.
+	DedupModeTimestamp = "timestamp"
 )
 
 var DefaultSettings = map[string]string{
 	SettingKeyMaxAge:        "2678400", // 60*60*24*31days
 	SettingKeyStoreInterval: "60",      // 60sec
+	SettingKeyThrottleScope: ThrottleScopeSensor,
+	SettingKeyStoreMode:     StoreModeSample,
+	SettingKeyDedupMode:     DedupModeMessageID,
+}
+
+// Validate checks a value before it is persisted for a known key.
+func Validate(key, value string) error {
+	switch key {
+	case SettingKeyMaxAge, SettingKeyStoreInterval:
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%s must be a number of seconds", key)
+		}
+		if seconds < 0 {
+			return fmt.Errorf("%s must not be negative", key)
+		}
+
+	case SettingKeyThrottleScope:
+		if value != ThrottleScopeSensor && value != ThrottleScopeMeasurement {
+			return fmt.Errorf("%s must be '%s' or '%s'", key, ThrottleScopeSensor, ThrottleScopeMeasurement)
+		}
+
+	case SettingKeyStoreMode:
+		if value != StoreModeSample && value != StoreModeAggregate {
+			return fmt.Errorf("%s must be '%s' or '%s'", key, StoreModeSample, StoreModeAggregate)
+		}
+
+	case SettingKeyDedupMode:
+		if value != DedupModeMessageID && value != DedupModeTimestamp {
+			return fmt.Errorf("%s must be '%s' or '%s'", key, DedupModeMessageID, DedupModeTimestamp)
+		}
+	}
+	return nil
 }
@@ -1,24 +1,77 @@
 package settings
 
-import "time"
+import (
+	"sync"
+	"time"
+)
 
+// SettingsCache holds the current settings. The settings handler updates it
+// while every ingestion path reads it, so all access goes through mu.
 type SettingsCache struct {
+	mu            sync.RWMutex
 	storeInterval time.Duration
 	maxAge        time.Duration
+	throttleScope string
+	storeMode     string
+	dedupMode     string
 }
 
 func (s *SettingsCache) GetStoreInterval() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.storeInterval
 }
 
 func (s *SettingsCache) SetStoreInterval(value time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.storeInterval = value
 }
 
 func (s *SettingsCache) GetMaxAge() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.maxAge
 }
 
 func (s *SettingsCache) SetMaxAge(value time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.maxAge = value
 }
+
+func (s *SettingsCache) GetThrottleScope() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.throttleScope
+}
+
+func (s *SettingsCache) SetThrottleScope(value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.throttleScope = value
+}
+
+func (s *SettingsCache) GetStoreMode() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.storeMode
+}
+
+func (s *SettingsCache) SetStoreMode(value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.storeMode = value
+}
+
+func (s *SettingsCache) GetDedupMode() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.dedupMode
+}
+
+func (s *SettingsCache) SetDedupMode(value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dedupMode = value
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sensor/cmd/api/calibration"
+	"time"
+)
+
+func (s *SQLStorage) createCalibrationProfileTable() error {
+	sqlCreate := `
+    CREATE TABLE IF NOT EXISTS calibration_profile (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        sensor_id TEXT NOT NULL,
+        measurement TEXT NOT NULL,
+        kind TEXT NOT NULL,
+        value_offset REAL NOT NULL DEFAULT 0,
+        value_gain REAL,
+        coefficients TEXT NOT NULL,
+        effective_from_unix INTEGER NOT NULL,
+        created_at_unix INTEGER NOT NULL
+    );
+    CREATE INDEX IF NOT EXISTS idx_calibration_profile_sensor
+    ON calibration_profile(sensor_id, measurement, effective_from_unix);
+    `
+	_, err := s.DB.Exec(sqlCreate)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *SQLStorage) GetCalibrationProfiles(ctx context.Context) ([]calibration.Profile, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, sensor_id, measurement, kind, value_offset, value_gain, coefficients, effective_from_unix, created_at_unix
+		FROM calibration_profile
+		ORDER BY sensor_id, measurement, effective_from_unix`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []calibration.Profile{}
+	for rows.Next() {
+		var p calibration.Profile
+		var coefficients string
+		var effectiveFromUnix, createdAtUnix int64
+		if err := rows.Scan(&p.ID, &p.SensorID, &p.Measurement, &p.Kind, &p.Offset, &p.Gain, &coefficients, &effectiveFromUnix, &createdAtUnix); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(coefficients), &p.Coefficients); err != nil {
+			return nil, err
+		}
+		p.EffectiveFrom = time.Unix(effectiveFromUnix, 0).UTC()
+		p.CreatedAt = time.Unix(createdAtUnix, 0).UTC()
+		out = append(out, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *SQLStorage) CreateCalibrationProfile(ctx context.Context, p calibration.Profile) (calibration.Profile, error) {
+	coefficients, err := json.Marshal(p.Coefficients)
+	if err != nil {
+		return calibration.Profile{}, err
+	}
+	p.CreatedAt = time.Now().UTC()
+	res, err := s.DB.ExecContext(ctx, `
+		INSERT INTO calibration_profile (sensor_id, measurement, kind, value_offset, value_gain, coefficients, effective_from_unix, created_at_unix)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.SensorID, p.Measurement, p.Kind, p.Offset, p.Gain, string(coefficients), p.EffectiveFrom.UTC().Unix(), p.CreatedAt.Unix())
+	if err != nil {
+		s.errorLog.Printf("Failed to create calibration profile %s %s %v", p.SensorID, p.Measurement, err)
+		return calibration.Profile{}, err
+	}
+	if p.ID, err = res.LastInsertId(); err != nil {
+		return calibration.Profile{}, err
+	}
+	return p, nil
+}
+
+// DeleteCalibrationProfile removes a profile and reports whether there
+// was one.
+func (s *SQLStorage) DeleteCalibrationProfile(ctx context.Context, sensorID, measurement string, id int64) (bool, error) {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM calibration_profile WHERE id = ? AND sensor_id = ? AND measurement = ?`, id, sensorID, measurement)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// NearestValue returns the value of the sensor's measurement stored closest
+// to timestamp, no further than window away.
+func (s *SQLStorage) NearestValue(ctx context.Context, sensorID, measurement string, timestamp time.Time, window time.Duration) (float64, bool, error) {
+	ts := timestamp.UnixMilli()
+	var value float64
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT value
+		FROM measurement
+		WHERE sensor_id = ? AND measurement = ? AND timestamp_ms BETWEEN ? AND ?
+		ORDER BY ABS(timestamp_ms - ?)
+		LIMIT 1`,
+		sensorID, measurement, ts-window.Milliseconds(), ts+window.Milliseconds(), ts).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return value, true, nil
+}
+
+// GetMeasurementsForCalibration returns up to limit records of a sensor
+// measurement taken in [from, to] with an id above afterID, by id.
+func (s *SQLStorage) GetMeasurementsForCalibration(ctx context.Context, sensorID, measurement string, from, to time.Time, afterID int64, limit int) ([]MeasurementRecord, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT `+measurementColumns+`
+		FROM measurement
+		WHERE sensor_id = ? AND measurement = ? AND timestamp_ms BETWEEN ? AND ? AND id > ?
+		ORDER BY id
+		LIMIT ?`,
+		sensorID, measurement, from.UnixMilli(), to.UnixMilli(), afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []MeasurementRecord{}
+	for rows.Next() {
+		m, err := scanMeasurement(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UpdateCalibration replaces the value of a single-sample record together
+// with its raw reading and the profile it was calibrated with.
+func (t *Tx) UpdateCalibration(ctx context.Context, id int64, value float64, rawValue *float64, rawUnit *string, calibrationID *int64) error {
+	_, err := t.tx.ExecContext(ctx, `
+		UPDATE measurement
+		SET value = ?, value_min = ?, value_max = ?, raw_value = ?, raw_unit = ?, calibration_id = ?
+		WHERE id = ?`,
+		value, value, value, rawValue, rawUnit, calibrationID, id)
+	return err
+}
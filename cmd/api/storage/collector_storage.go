@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// CollectorMapping takes one value out of a device response. Path is a
+// JSON path such as StatusSNS.SDS0X1["PM2.5"].
+type CollectorMapping struct {
+	Path        string  `json:"path"`
+	Measurement string  `json:"measurement"`
+	Parameter   *string `json:"parameter,omitempty"`
+	Unit        *string `json:"unit,omitempty"`
+}
+
+// CollectorTarget is a device whose HTTP endpoint is polled for readings.
+type CollectorTarget struct {
+	ID              int64              `json:"id"`
+	SensorID        string             `json:"sensor_id"`
+	SensorName      string             `json:"sensor_name"`
+	URL             string             `json:"url"`
+	IntervalSeconds int                `json:"interval_seconds"`
+	Mappings        []CollectorMapping `json:"mappings"`
+	CreatedAt       time.Time          `json:"created_at"`
+}
+
+func (s *SQLStorage) createCollectorTargetTable() error {
+	sqlCreate := `
+    CREATE TABLE IF NOT EXISTS collector_target (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        sensor_id TEXT NOT NULL,
+        sensor_name TEXT NOT NULL,
+        url TEXT NOT NULL,
+        interval_seconds INTEGER NOT NULL,
+        mappings TEXT NOT NULL,
+        created_at_unix INTEGER NOT NULL
+    );
+    `
+	_, err := s.DB.Exec(sqlCreate)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *SQLStorage) GetCollectorTargets(ctx context.Context) ([]CollectorTarget, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, sensor_id, sensor_name, url, interval_seconds, mappings, created_at_unix
+		FROM collector_target
+		ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []CollectorTarget{}
+	for rows.Next() {
+		var t CollectorTarget
+		var mappings string
+		var createdAtUnix int64
+		if err := rows.Scan(&t.ID, &t.SensorID, &t.SensorName, &t.URL, &t.IntervalSeconds, &mappings, &createdAtUnix); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(mappings), &t.Mappings); err != nil {
+			return nil, err
+		}
+		t.CreatedAt = time.Unix(createdAtUnix, 0).UTC()
+		out = append(out, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *SQLStorage) CreateCollectorTarget(ctx context.Context, t CollectorTarget) (CollectorTarget, error) {
+	mappings, err := json.Marshal(t.Mappings)
+	if err != nil {
+		return CollectorTarget{}, err
+	}
+	t.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	res, err := s.DB.ExecContext(ctx, `
+		INSERT INTO collector_target (sensor_id, sensor_name, url, interval_seconds, mappings, created_at_unix)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		t.SensorID, t.SensorName, t.URL, t.IntervalSeconds, string(mappings), t.CreatedAt.Unix())
+	if err != nil {
+		s.errorLog.Printf("Failed to create collector target %s %s %v", t.SensorID, t.URL, err)
+		return CollectorTarget{}, err
+	}
+	if t.ID, err = res.LastInsertId(); err != nil {
+		return CollectorTarget{}, err
+	}
+	return t, nil
+}
+
+// DeleteCollectorTarget removes a target and reports whether there was
+// one.
+func (s *SQLStorage) DeleteCollectorTarget(ctx context.Context, id int64) (bool, error) {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM collector_target WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"sensor/cmd/api/derive"
+	"time"
+)
+
+// createDerivedFormulaTable creates the formula table and fills it with
+// derive.DefaultFormulas. Defaults are only stored on creation so that
+// formulas removed through the API stay removed.
+func (s *SQLStorage) createDerivedFormulaTable() error {
+	var count int
+	if err := s.DB.QueryRow(`
+		SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'derived_formula'
+	`).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	sqlCreate := `
+    CREATE TABLE IF NOT EXISTS derived_formula (
+        measurement TEXT PRIMARY KEY,
+        kind TEXT NOT NULL,
+        inputs TEXT NOT NULL,
+        kappa REAL,
+        updated_at_unix INTEGER NOT NULL
+    )
+    `
+	if _, err := s.DB.Exec(sqlCreate); err != nil {
+		return err
+	}
+	for _, f := range derive.DefaultFormulas {
+		if _, err := s.UpsertDerivedFormula(context.Background(), f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStorage) GetDerivedFormulas(ctx context.Context) ([]derive.Formula, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT measurement, kind, inputs, kappa, updated_at_unix
+		FROM derived_formula
+		ORDER BY measurement`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []derive.Formula{}
+	for rows.Next() {
+		var f derive.Formula
+		var inputs string
+		var updatedAtUnix int64
+		if err := rows.Scan(&f.Measurement, &f.Kind, &inputs, &f.Kappa, &updatedAtUnix); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(inputs), &f.Inputs); err != nil {
+			return nil, err
+		}
+		f.UpdatedAt = time.Unix(updatedAtUnix, 0).UTC()
+		out = append(out, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *SQLStorage) UpsertDerivedFormula(ctx context.Context, f derive.Formula) (derive.Formula, error) {
+	inputs, err := json.Marshal(f.Inputs)
+	if err != nil {
+		return derive.Formula{}, err
+	}
+	f.UpdatedAt = time.Now().UTC()
+	_, err = s.DB.ExecContext(ctx, `
+		INSERT INTO derived_formula (measurement, kind, inputs, kappa, updated_at_unix)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(measurement) DO UPDATE SET
+			kind = excluded.kind,
+			inputs = excluded.inputs,
+			kappa = excluded.kappa,
+			updated_at_unix = excluded.updated_at_unix`,
+		f.Measurement, f.Kind, string(inputs), f.Kappa, f.UpdatedAt.Unix())
+	if err != nil {
+		s.errorLog.Printf("Failed to upsert derived formula %s %v", f.Measurement, err)
+		return derive.Formula{}, err
+	}
+	return f, nil
+}
+
+// DeleteDerivedFormula removes the formula of measurement and reports
+// whether there was one.
+func (s *SQLStorage) DeleteDerivedFormula(ctx context.Context, measurement string) (bool, error) {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM derived_formula WHERE measurement = ?`, measurement)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// IdempotencyKeyTTL is how long a response is kept for replay after the
+// request that produced it.
+const IdempotencyKeyTTL = 24 * time.Hour
+
+// IdempotentResponse is the stored answer to a request sent with an
+// Idempotency-Key header.
+type IdempotentResponse struct {
+	Status      int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+}
+
+func (s *SQLStorage) createIdempotencyKeyTable() error {
+	sqlCreate := `
+    CREATE TABLE IF NOT EXISTS idempotency_key (
+        scope TEXT NOT NULL,
+        key TEXT NOT NULL,
+        status INTEGER NOT NULL,
+        content_type TEXT NOT NULL,
+        body BLOB NOT NULL,
+        created_at_ms INTEGER NOT NULL,
+        PRIMARY KEY (scope, key)
+    )
+    `
+	_, err := s.DB.Exec(sqlCreate)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetIdempotentResponse returns the response stored for key within scope,
+// or nil when there is none.
+func (s *SQLStorage) GetIdempotentResponse(ctx context.Context, scope, key string) (*IdempotentResponse, error) {
+	row := s.DB.QueryRowContext(ctx, `
+		SELECT status, content_type, body, created_at_ms
+		FROM idempotency_key
+		WHERE scope = ? AND key = ?`,
+		scope, key)
+	var resp IdempotentResponse
+	var createdAtMs int64
+	if err := row.Scan(&resp.Status, &resp.ContentType, &resp.Body, &createdAtMs); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil // not found
+		}
+		return nil, err
+	}
+	resp.CreatedAt = time.UnixMilli(createdAtMs).UTC()
+	return &resp, nil
+}
+
+// SaveIdempotentResponse stores resp for key within scope in t. The first
+// stored response wins.
+func (t *Tx) SaveIdempotentResponse(ctx context.Context, scope, key string, resp IdempotentResponse) error {
+	_, err := t.tx.ExecContext(ctx, `
+		INSERT INTO idempotency_key (scope, key, status, content_type, body, created_at_ms)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(scope, key) DO NOTHING`,
+		scope, key, resp.Status, resp.ContentType, resp.Body, resp.CreatedAt.UnixMilli())
+	return err
+}
+
+// DeleteIdempotencyKeys removes responses stored before cutOff.
+func (t *Tx) DeleteIdempotencyKeys(ctx context.Context, cutOff time.Time) (int64, error) {
+	res, err := t.tx.ExecContext(ctx, `DELETE FROM idempotency_key WHERE created_at_ms < ?`, cutOff.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -2,6 +2,8 @@
 package storage
 
 import (
+	"database/sql"
+	"errors"
 	"sensor/cmd/api/models"
 	"sensor/cmd/api/pagination"
 	"time"
@@ -12,69 +14,251 @@ import (
 )
 
 type Storage interface {
-	CreateMeasurement(ctx context.Context, sensorID *string, sensorName *string, m *models.MeasurementValue, timestamp time.Time) (MeasurementRecord, error)
-	GetMeasurementsPage(sensorID string, limit int, after *pagination.MeasurementCursor) ([]MeasurementRecord, error)
+	CreateMeasurement(ctx context.Context, sensorID *string, sensorName *string, m *models.MeasurementValue, timestamp time.Time, rule DedupRule) (MeasurementRecord, error)
+	GetMeasurementsPage(sensorID string, limit int, after *pagination.MeasurementCursor, within TimeRange) ([]MeasurementRecord, error)
 }
 
 type MeasurementRecord struct {
-	ID          int64     `json:"id"`
-	SensorName  *string   `json:"sensor_name"`
-	SensorID    *string   `json:"sensor_id"`
-	Measurement string    `json:"measurement"`
-	Parameter   *string   `json:"parameter,omitempty"`
-	Value       float64   `json:"value"`
-	Unit        *string   `json:"unit,omitempty"`
-	Timestamp   time.Time `json:"timestamp"`
-	CreatedAt   time.Time `json:"created_at"`
-}
-
-func (s *SQLStorage) CreateMeasurement(ctx context.Context, sensorID, sensorName *string, m *models.MeasurementValue, timestamp time.Time) (MeasurementRecord, error) {
-	currTimestamp := time.Now().UTC()
-	result, err := s.DB.ExecContext(ctx,
-		`INSERT INTO measurement (sensor_id, sensor_name, measurement, parameter, value, unit, timestamp_unix, created_at_unix) 
-        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
-		sensorID, sensorName, m.Measurement, m.Parameter, m.Value, m.Unit, timestamp.Unix(), currTimestamp.Unix())
+	ID          int64    `json:"id"`
+	SensorName  *string  `json:"sensor_name"`
+	SensorID    *string  `json:"sensor_id"`
+	Measurement string   `json:"measurement"`
+	Parameter   *string  `json:"parameter,omitempty"`
+	Value       float64  `json:"value"`
+	Min         *float64 `json:"min,omitempty"`
+	Max         *float64 `json:"max,omitempty"`
+	SampleCount int64    `json:"sample_count"`
+	Unit        *string  `json:"unit,omitempty"`
+	MessageID   *string  `json:"message_id,omitempty"`
+	Flag        *string  `json:"flag,omitempty"`
+	RawValue    *float64 `json:"raw_value,omitempty"`
+	RawUnit     *string  `json:"raw_unit,omitempty"`
+	// CalibrationID is the calibration profile applied to Value.
+	CalibrationID *int64 `json:"calibration_id,omitempty"`
+	// Derived names the formula that computed Value from other readings.
+	Derived   *string   `json:"derived,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	CreatedAt time.Time `json:"created_at"`
+	// Duplicate marks a previously stored record returned in place of a
+	// reading that was already received.
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+// DedupRule describes which stored rows count as the same reading. Rows
+// with the same message_id always do; with ByTimestamp so do rows with the
+// same sensor_id, measurement, parameter and timestamp.
+type DedupRule struct {
+	MessageID   *string
+	ByTimestamp bool
+}
+
+func (r DedupRule) Active() bool {
+	return r.MessageID != nil || r.ByTimestamp
+}
+
+func (s *SQLStorage) CreateMeasurement(ctx context.Context, sensorID, sensorName *string, m *models.MeasurementValue, timestamp time.Time, rule DedupRule) (MeasurementRecord, error) {
+	return createMeasurement(ctx, s.DB, sensorID, sensorName, m, timestamp, rule)
+}
+
+// FindDuplicate returns the stored record matching rule, if any.
+func (s *SQLStorage) FindDuplicate(ctx context.Context, sensorID string, m *models.MeasurementValue, timestamp time.Time, rule DedupRule) (MeasurementRecord, bool, error) {
+	return findDuplicate(ctx, s.DB, sensorID, m, timestamp, rule)
+}
+
+// createMeasurement stores a reading unless rule finds it already stored,
+// in which case the earlier record is returned with Duplicate set.
+func createMeasurement(ctx context.Context, db dbtx, sensorID, sensorName *string, m *models.MeasurementValue, timestamp time.Time, rule DedupRule) (MeasurementRecord, error) {
+	if rule.Active() && sensorID != nil {
+		record, found, err := findDuplicate(ctx, db, *sensorID, m, timestamp, rule)
+		if err != nil {
+			return MeasurementRecord{}, err
+		}
+		if found {
+			return record, nil
+		}
+	}
+
+	timestamp = millis(timestamp)
+	currTimestamp := millis(time.Now())
+	result, err := db.ExecContext(ctx,
+		`INSERT INTO measurement (sensor_id, sensor_name, measurement, parameter, value, value_min, value_max, sample_count, unit, message_id, flag, raw_value, raw_unit, calibration_id, derived, timestamp_ms, created_at_ms) 
+        VALUES (?, ?, ?, ?, ?, ?, ?, 1, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT DO NOTHING`,
+		sensorID, sensorName, m.Measurement, m.Parameter, m.Value, m.Value, m.Value, m.Unit, rule.MessageID, m.Flag, m.RawValue, m.RawUnit, m.CalibrationID, m.Derived, timestamp.UnixMilli(), currTimestamp.UnixMilli())
+	if err != nil {
+		return MeasurementRecord{}, err
+	}
+	inserted, err := result.RowsAffected()
 	if err != nil {
 		return MeasurementRecord{}, err
 	}
+	if inserted == 0 && sensorID != nil {
+		// A concurrent request stored the same message_id first.
+		record, found, err := findDuplicate(ctx, db, *sensorID, m, timestamp, DedupRule{MessageID: rule.MessageID})
+		if err != nil {
+			return MeasurementRecord{}, err
+		}
+		if found {
+			return record, nil
+		}
+		return MeasurementRecord{}, errors.New("measurement was neither stored nor found")
+	}
 	id, err := result.LastInsertId()
 	if err != nil {
 		return MeasurementRecord{}, err
 	}
+	value := m.Value
 	return MeasurementRecord{
-		ID:          id,
-		SensorName:  sensorName,
-		SensorID:    sensorID,
-		Measurement: m.Measurement,
-		Parameter:   m.Parameter,
-		Value:       m.Value,
-		Unit:        m.Unit,
-		Timestamp:   timestamp,
-		CreatedAt:   currTimestamp,
+		ID:            id,
+		SensorName:    sensorName,
+		SensorID:      sensorID,
+		Measurement:   m.Measurement,
+		Parameter:     m.Parameter,
+		Value:         m.Value,
+		Min:           &value,
+		Max:           &value,
+		SampleCount:   1,
+		Unit:          m.Unit,
+		MessageID:     rule.MessageID,
+		Flag:          m.Flag,
+		RawValue:      m.RawValue,
+		RawUnit:       m.RawUnit,
+		CalibrationID: m.CalibrationID,
+		Derived:       m.Derived,
+		Timestamp:     timestamp,
+		CreatedAt:     currTimestamp,
 	}, nil
 }
 
-func (s *SQLStorage) GetMeasurementsPage(sensorID string, limit int, after *pagination.MeasurementCursor) ([]MeasurementRecord, error) {
+// AggregateMeasurement folds value into an already stored record, keeping
+// the mean in value together with min, max and the number of samples.
+func (s *SQLStorage) AggregateMeasurement(ctx context.Context, id int64, value float64) (MeasurementRecord, error) {
+	return aggregateMeasurement(ctx, s.DB, id, value)
+}
+
+func aggregateMeasurement(ctx context.Context, db dbtx, id int64, value float64) (MeasurementRecord, error) {
+	row := db.QueryRowContext(ctx, `
+		UPDATE measurement
+		SET value = (value * sample_count + ?) / (sample_count + 1),
+		    value_min = MIN(COALESCE(value_min, value), ?),
+		    value_max = MAX(COALESCE(value_max, value), ?),
+		    sample_count = sample_count + 1
+		WHERE id = ?
+		RETURNING `+measurementColumns,
+		value, value, value, id)
+	return scanMeasurement(row)
+}
+
+func findDuplicate(ctx context.Context, db dbtx, sensorID string, m *models.MeasurementValue, timestamp time.Time, rule DedupRule) (MeasurementRecord, bool, error) {
+	var match string
+	args := []any{sensorID, m.Measurement, m.Parameter}
+	switch {
+	case rule.MessageID != nil && rule.ByTimestamp:
+		match = "(message_id = ? OR timestamp_ms = ?)"
+		args = append(args, *rule.MessageID, timestamp.UnixMilli())
+	case rule.MessageID != nil:
+		match = "message_id = ?"
+		args = append(args, *rule.MessageID)
+	case rule.ByTimestamp:
+		match = "timestamp_ms = ?"
+		args = append(args, timestamp.UnixMilli())
+	default:
+		return MeasurementRecord{}, false, nil
+	}
+
+	row := db.QueryRowContext(ctx, `
+		SELECT `+measurementColumns+`
+		FROM measurement
+		WHERE sensor_id = ? AND measurement = ? AND parameter IS ? AND `+match+`
+		ORDER BY id
+		LIMIT 1`,
+		args...)
+	record, err := scanMeasurement(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return MeasurementRecord{}, false, nil
+	}
+	if err != nil {
+		return MeasurementRecord{}, false, err
+	}
+	record.Duplicate = true
+	return record, true, nil
+}
+
+const measurementColumns = `id, sensor_id, sensor_name, measurement, parameter, value, value_min, value_max, sample_count, unit, message_id, flag, raw_value, raw_unit, calibration_id, derived, timestamp_ms, created_at_ms`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanMeasurement(row rowScanner) (MeasurementRecord, error) {
+	var m MeasurementRecord
+	var tsMillis, createdAtMillis int64
+	if err := row.Scan(
+		&m.ID, &m.SensorID, &m.SensorName, &m.Measurement, &m.Parameter, &m.Value, &m.Min, &m.Max, &m.SampleCount, &m.Unit, &m.MessageID, &m.Flag, &m.RawValue, &m.RawUnit, &m.CalibrationID, &m.Derived, &tsMillis, &createdAtMillis,
+	); err != nil {
+		return MeasurementRecord{}, err
+	}
+	m.Timestamp = time.UnixMilli(tsMillis).UTC()
+	m.CreatedAt = time.UnixMilli(createdAtMillis).UTC()
+	return m, nil
+}
+
+// millis truncates t to the millisecond precision of stored times.
+func millis(t time.Time) time.Time {
+	return time.UnixMilli(t.UnixMilli()).UTC()
+}
+
+// TimeRange selects readings taken in [From, To). A zero bound leaves
+// that side open.
+type TimeRange struct {
+	From time.Time
+	To   time.Time
+}
+
+func (r TimeRange) IsZero() bool {
+	return r.From.IsZero() && r.To.IsZero()
+}
+
+// GetMeasurementsPage returns up to limit+1 records of a sensor, newest
+// first. Without a time range the records are ordered by created_at; with
+// one they are limited to readings taken within it and ordered by reading
+// timestamp, and after must be a cursor of such a page.
+func (s *SQLStorage) GetMeasurementsPage(sensorID string, limit int, after *pagination.MeasurementCursor, within TimeRange) ([]MeasurementRecord, error) {
 	if limit <= 0 || limit > 200 {
 		limit = 50
 	}
 
 	args := []any{sensorID}
 	where := "WHERE sensor_id = ?"
+	orderColumn := "created_at_ms"
+	if !within.IsZero() {
+		orderColumn = "timestamp_ms"
+		if !within.From.IsZero() {
+			where += " AND timestamp_ms >= ?"
+			args = append(args, within.From.UnixMilli())
+		}
+		if !within.To.IsZero() {
+			where += " AND timestamp_ms < ?"
+			args = append(args, within.To.UnixMilli())
+		}
+	}
 
 	// Forward pagination: everything "after" the cursor in a DESC order
 	if after != nil {
-		where += " AND (created_at_unix < ? OR (created_at_unix = ? AND id < ?))"
-		cursorUnix := after.CreatedAt.UTC().Unix()
-		args = append(args, cursorUnix, cursorUnix, after.ID)
+		cursorMillis := after.CreatedAt.UnixMilli()
+		if after.Timestamp != nil {
+			cursorMillis = after.Timestamp.UnixMilli()
+		}
+		where += " AND (" + orderColumn + " < ? OR (" + orderColumn + " = ? AND id < ?))"
+		args = append(args, cursorMillis, cursorMillis, after.ID)
 	}
 
 	// Always keep the ORDER BY stable and matching the index
 	q := `
-		SELECT id, sensor_id, sensor_name, measurement, parameter, value, unit, timestamp_unix, created_at_unix
+		SELECT ` + measurementColumns + `
 		FROM measurement
 		` + where + `
-		ORDER BY created_at_unix DESC, id DESC
+		ORDER BY ` + orderColumn + ` DESC, id DESC
 		LIMIT ?
 	`
 	args = append(args, limit+1)
@@ -88,16 +272,11 @@ func (s *SQLStorage) GetMeasurementsPage(sensorID string, limit int, after *pagi
 
 	out := []MeasurementRecord{}
 	for rows.Next() {
-		var m MeasurementRecord
-		var tsUnix, createdAtUnix int64
-		if err := rows.Scan(
-			&m.ID, &m.SensorID, &m.SensorName, &m.Measurement, &m.Parameter, &m.Value, &m.Unit, &tsUnix, &createdAtUnix,
-		); err != nil {
+		m, err := scanMeasurement(rows)
+		if err != nil {
 			s.infoLog.Println("Failed Scan operation")
 			return nil, err
 		}
-		m.Timestamp = time.Unix(tsUnix, 0)
-		m.CreatedAt = time.Unix(createdAtUnix, 0)
 		out = append(out, m)
 	}
 	if err := rows.Err(); err != nil {
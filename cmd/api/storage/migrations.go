@@ -2,6 +2,7 @@ package storage
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 )
 
@@ -14,6 +15,162 @@ func NewMigrations(db *sql.DB, logger *log.Logger) *Migrations {
 	return &Migrations{DB: db, infoLog: logger}
 }
 
+// Run brings tables created by older versions up to the current schema.
+// Every step is idempotent, so it is safe to run on each start.
 func (m *Migrations) Run() error {
+	if err := m.convertTimesToMillis(); err != nil {
+		return fmt.Errorf("convert times to milliseconds: %w", err)
+	}
+	if err := m.addMeasurementAggregateColumns(); err != nil {
+		return fmt.Errorf("add measurement aggregate columns: %w", err)
+	}
+	if err := m.addMeasurementDedup(); err != nil {
+		return fmt.Errorf("add measurement dedup: %w", err)
+	}
+	if err := m.addColumn("measurement", "flag", "TEXT"); err != nil {
+		return fmt.Errorf("add measurement flag: %w", err)
+	}
+	if err := m.addMeasurementRawColumns(); err != nil {
+		return fmt.Errorf("add measurement raw columns: %w", err)
+	}
+	if err := m.addColumn("measurement", "calibration_id", "INTEGER"); err != nil {
+		return fmt.Errorf("add measurement calibration id: %w", err)
+	}
+	if err := m.addColumn("measurement", "derived", "TEXT"); err != nil {
+		return fmt.Errorf("add measurement derived: %w", err)
+	}
+	// Serves GET /api/measurements/{sensor_id}?from=&to=, which pages a
+	// sensor's readings by timestamp.
+	if _, err := m.DB.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_measurement_sensor_timestamp
+		ON measurement(sensor_id, timestamp_ms DESC, id DESC)
+	`); err != nil {
+		return fmt.Errorf("add measurement timestamp index: %w", err)
+	}
+	return nil
+}
+
+// millisColumns are the reading, sensor and idempotency times that older
+// versions stored as whole Unix seconds, by table.
+var millisColumns = []struct{ table, from, to string }{
+	{"measurement", "timestamp_unix", "timestamp_ms"},
+	{"measurement", "created_at_unix", "created_at_ms"},
+	{"quarantine", "timestamp_unix", "timestamp_ms"},
+	{"quarantine", "received_at_unix", "received_at_ms"},
+	{"sensor", "last_seen_unix", "last_seen_ms"},
+	{"idempotency_key", "created_at_unix", "created_at_ms"},
+}
+
+// convertTimesToMillis renames the second columns of millisColumns and
+// scales their rows to Unix milliseconds, each column in one transaction,
+// and indexes measurements for paging by created_at_ms. Indexes on a
+// renamed column follow it, except the paging index of older versions,
+// which is replaced to carry the new column name.
+func (m *Migrations) convertTimesToMillis() error {
+	for _, c := range millisColumns {
+		exists, err := m.columnExists(c.table, c.from)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		tx, err := m.DB.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", c.table, c.from, c.to)); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		res, err := tx.Exec(fmt.Sprintf("UPDATE %s SET %s = %s * 1000", c.table, c.to, c.to))
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		m.infoLog.Printf("Migration converted %s.%s to %s for %d rows", c.table, c.from, c.to, n)
+	}
+
+	if _, err := m.DB.Exec(`DROP INDEX IF EXISTS idx_measurement_created_at_unix_id`); err != nil {
+		return err
+	}
+	_, err := m.DB.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_measurement_created_at_ms_id
+		ON measurement(created_at_ms DESC, id DESC)
+	`)
+	return err
+}
+
+func (m *Migrations) addMeasurementAggregateColumns() error {
+	if err := m.addColumn("measurement", "value_min", "REAL"); err != nil {
+		return err
+	}
+	if err := m.addColumn("measurement", "value_max", "REAL"); err != nil {
+		return err
+	}
+	if err := m.addColumn("measurement", "sample_count", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// addMeasurementDedup adds message_id, which is unique per sensor reading,
+// and an index for looking up readings by sensor, measurement and time.
+func (m *Migrations) addMeasurementDedup() error {
+	if err := m.addColumn("measurement", "message_id", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := m.DB.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_measurement_message_id
+		ON measurement(sensor_id, message_id, measurement, COALESCE(parameter, ''))
+		WHERE message_id IS NOT NULL
+	`); err != nil {
+		return err
+	}
+	_, err := m.DB.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_measurement_reading
+		ON measurement(sensor_id, measurement, parameter, timestamp_ms)
+	`)
+	return err
+}
+
+// addMeasurementRawColumns adds the value and unit as received for readings
+// converted to the canonical unit of their measurement.
+func (m *Migrations) addMeasurementRawColumns() error {
+	if err := m.addColumn("measurement", "raw_value", "REAL"); err != nil {
+		return err
+	}
+	return m.addColumn("measurement", "raw_unit", "TEXT")
+}
+
+func (m *Migrations) columnExists(table, column string) (bool, error) {
+	var count int
+	err := m.DB.QueryRow(`
+		SELECT COUNT(*)
+		FROM pragma_table_info(?)
+		WHERE name = ?
+	`, table, column).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (m *Migrations) addColumn(table, column, definition string) error {
+	exists, err := m.columnExists(table, column)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	if _, err := m.DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return err
+	}
+	m.infoLog.Printf("Migration added column %s.%s", table, column)
 	return nil
 }
@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sensor/cmd/api/models"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrQuarantineNotFound = errors.New("quarantined reading not found")
+	// ErrNotReleasable is returned for readings whose value cannot be
+	// stored as a measurement, such as NaN.
+	ErrNotReleasable = errors.New("quarantined reading has no storable value")
+)
+
+// QuarantineRecord is a reading that broke a validation rule with the
+// reject action. Value is empty for NaN and infinite values; RawValue
+// always holds the value as received.
+type QuarantineRecord struct {
+	ID          int64     `json:"id"`
+	SensorID    string    `json:"sensor_id"`
+	SensorName  string    `json:"sensor_name"`
+	Measurement string    `json:"measurement"`
+	Parameter   *string   `json:"parameter,omitempty"`
+	Value       *float64  `json:"value"`
+	RawValue    string    `json:"raw_value"`
+	Unit        *string   `json:"unit,omitempty"`
+	MessageID   *string   `json:"message_id,omitempty"`
+	Reason      string    `json:"reason"`
+	Timestamp   time.Time `json:"timestamp"`
+	ReceivedAt  time.Time `json:"received_at"`
+}
+
+func (s *SQLStorage) createQuarantineTable() error {
+	sqlCreate := `
+    CREATE TABLE IF NOT EXISTS quarantine (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        sensor_id TEXT NOT NULL,
+        sensor_name TEXT NOT NULL,
+        measurement TEXT NOT NULL,
+        parameter TEXT,
+        value REAL,
+        raw_value TEXT NOT NULL,
+        unit TEXT,
+        message_id TEXT,
+        reason TEXT NOT NULL,
+        timestamp_ms INTEGER NOT NULL,
+        received_at_ms INTEGER NOT NULL
+    )
+    `
+	_, err := s.DB.Exec(sqlCreate)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *SQLStorage) Quarantine(ctx context.Context, sensorID, sensorName *string, m *models.MeasurementValue, timestamp time.Time, messageID *string, reason string) (QuarantineRecord, error) {
+	return quarantineMeasurement(ctx, s.DB, sensorID, sensorName, m, timestamp, messageID, reason)
+}
+
+func (t *Tx) Quarantine(ctx context.Context, sensorID, sensorName *string, m *models.MeasurementValue, timestamp time.Time, messageID *string, reason string) (QuarantineRecord, error) {
+	return quarantineMeasurement(ctx, t.tx, sensorID, sensorName, m, timestamp, messageID, reason)
+}
+
+func quarantineMeasurement(ctx context.Context, db dbtx, sensorID, sensorName *string, m *models.MeasurementValue, timestamp time.Time, messageID *string, reason string) (QuarantineRecord, error) {
+	record := QuarantineRecord{
+		SensorID:    *sensorID,
+		SensorName:  *sensorName,
+		Measurement: m.Measurement,
+		Parameter:   m.Parameter,
+		RawValue:    strconv.FormatFloat(m.Value, 'g', -1, 64),
+		Unit:        m.Unit,
+		MessageID:   messageID,
+		Reason:      reason,
+		Timestamp:   millis(timestamp),
+		ReceivedAt:  millis(time.Now()),
+	}
+	if !math.IsNaN(m.Value) && !math.IsInf(m.Value, 0) {
+		value := m.Value
+		record.Value = &value
+	}
+
+	result, err := db.ExecContext(ctx, `
+		INSERT INTO quarantine (sensor_id, sensor_name, measurement, parameter, value, raw_value, unit, message_id, reason, timestamp_ms, received_at_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		record.SensorID, record.SensorName, record.Measurement, record.Parameter, record.Value, record.RawValue,
+		record.Unit, record.MessageID, record.Reason, record.Timestamp.UnixMilli(), record.ReceivedAt.UnixMilli())
+	if err != nil {
+		return QuarantineRecord{}, err
+	}
+	if record.ID, err = result.LastInsertId(); err != nil {
+		return QuarantineRecord{}, err
+	}
+	return record, nil
+}
+
+const quarantineColumns = `id, sensor_id, sensor_name, measurement, parameter, value, raw_value, unit, message_id, reason, timestamp_ms, received_at_ms`
+
+func scanQuarantine(row rowScanner) (QuarantineRecord, error) {
+	var q QuarantineRecord
+	var tsMillis, receivedAtMillis int64
+	if err := row.Scan(
+		&q.ID, &q.SensorID, &q.SensorName, &q.Measurement, &q.Parameter, &q.Value, &q.RawValue, &q.Unit, &q.MessageID, &q.Reason, &tsMillis, &receivedAtMillis,
+	); err != nil {
+		return QuarantineRecord{}, err
+	}
+	q.Timestamp = time.UnixMilli(tsMillis).UTC()
+	q.ReceivedAt = time.UnixMilli(receivedAtMillis).UTC()
+	return q, nil
+}
+
+// GetQuarantinePage lists quarantined readings newest first. An empty
+// sensorID lists all sensors; beforeID > 0 continues after a previous page.
+func (s *SQLStorage) GetQuarantinePage(ctx context.Context, sensorID string, limit int, beforeID int64) ([]QuarantineRecord, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	where := "WHERE 1 = 1"
+	args := []any{}
+	if len(sensorID) > 0 {
+		where += " AND sensor_id = ?"
+		args = append(args, sensorID)
+	}
+	if beforeID > 0 {
+		where += " AND id < ?"
+		args = append(args, beforeID)
+	}
+	args = append(args, limit)
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT `+quarantineColumns+`
+		FROM quarantine
+		`+where+`
+		ORDER BY id DESC
+		LIMIT ?`,
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []QuarantineRecord{}
+	for rows.Next() {
+		q, err := scanQuarantine(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, q)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ReleaseQuarantine stores a quarantined reading as a measurement in tx,
+// flagged with the original reason, and removes it from quarantine.
+// Validation rules are not applied again.
+func (t *Tx) ReleaseQuarantine(ctx context.Context, id int64) (MeasurementRecord, error) {
+	q, err := scanQuarantine(t.tx.QueryRowContext(ctx, `SELECT `+quarantineColumns+` FROM quarantine WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return MeasurementRecord{}, ErrQuarantineNotFound
+	}
+	if err != nil {
+		return MeasurementRecord{}, err
+	}
+	if q.Value == nil {
+		return MeasurementRecord{}, fmt.Errorf("%w: %s", ErrNotReleasable, q.RawValue)
+	}
+
+	flag := "released: " + q.Reason
+	m := models.MeasurementValue{
+		Measurement: q.Measurement,
+		Parameter:   q.Parameter,
+		Value:       *q.Value,
+		Unit:        q.Unit,
+		Flag:        &flag,
+	}
+	if err := t.UpsertSensor(ctx, &q.SensorID, &q.SensorName, q.Timestamp); err != nil {
+		return MeasurementRecord{}, err
+	}
+	if err := t.UpdateSensorMeasurement(ctx, q.SensorID, q.Measurement); err != nil {
+		return MeasurementRecord{}, err
+	}
+	record, err := t.CreateMeasurement(ctx, &q.SensorID, &q.SensorName, &m, q.Timestamp, DedupRule{MessageID: q.MessageID})
+	if err != nil {
+		return MeasurementRecord{}, err
+	}
+	if _, err := t.tx.ExecContext(ctx, `DELETE FROM quarantine WHERE id = ?`, id); err != nil {
+		return MeasurementRecord{}, err
+	}
+	return record, nil
+}
+
+// PurgeQuarantine deletes quarantined readings. id > 0 selects one reading;
+// otherwise an empty sensorID matches all sensors and a zero before matches
+// any receive time.
+func (t *Tx) PurgeQuarantine(ctx context.Context, id int64, sensorID string, before time.Time) (int64, error) {
+	where := "WHERE 1 = 1"
+	args := []any{}
+	if id > 0 {
+		where += " AND id = ?"
+		args = append(args, id)
+	}
+	if len(sensorID) > 0 {
+		where += " AND sensor_id = ?"
+		args = append(args, sensorID)
+	}
+	if !before.IsZero() {
+		where += " AND received_at_ms < ?"
+		args = append(args, before.UnixMilli())
+	}
+	res, err := t.tx.ExecContext(ctx, `DELETE FROM quarantine `+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
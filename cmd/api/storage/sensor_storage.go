@@ -25,22 +25,25 @@ type SensorStorage interface {
 }
 
 func (s *SQLStorage) UpsertSensor(ctx context.Context, sensorID, sensorName *string, timestamp time.Time) error {
-	_, err := s.DB.ExecContext(ctx,
-		`INSERT INTO sensor (sensor_id, sensor_name, last_seen_unix)
-        VALUES (?, ?, ?)
-        ON CONFLICT(sensor_id) DO UPDATE SET last_seen_unix=excluded.last_seen_unix 
-        `,
-		sensorID, sensorName, timestamp.UTC().Unix())
-
-	if err != nil {
+	if err := upsertSensor(ctx, s.DB, sensorID, sensorName, timestamp); err != nil {
 		s.errorLog.Printf("Failed to add or update sensor id %s name %s %s", *sensorID, *sensorName, err)
 		return err
 	}
 	return nil
 }
 
+func upsertSensor(ctx context.Context, db dbtx, sensorID, sensorName *string, timestamp time.Time) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO sensor (sensor_id, sensor_name, last_seen_ms)
+        VALUES (?, ?, ?)
+        ON CONFLICT(sensor_id) DO UPDATE SET last_seen_ms=MAX(last_seen_ms, excluded.last_seen_ms)
+        `,
+		sensorID, sensorName, timestamp.UnixMilli())
+	return err
+}
+
 func (s *SQLStorage) GetAllSensors(ctx context.Context) ([]SensorItem, error) {
-	query := `SELECT sensor_id, sensor_name, last_seen_unix from sensor`
+	query := `SELECT sensor_id, sensor_name, last_seen_ms from sensor`
 	rows, err := s.DB.QueryContext(ctx, query)
 	if err != nil {
 		s.errorLog.Printf("Failed to fetch sensors %s", err)
@@ -58,7 +61,7 @@ func (s *SQLStorage) GetAllSensors(ctx context.Context) ([]SensorItem, error) {
 			s.errorLog.Printf("Failed to scan sensor row: %v", err)
 			return nil, err
 		}
-		item.LastSeen = time.Unix(timestamp, 0).UTC()
+		item.LastSeen = time.UnixMilli(timestamp).UTC()
 		result = append(result, item)
 	}
 
@@ -75,13 +78,7 @@ func (s *SQLStorage) UpdateSensorMeasurement(
 	sensorID string,
 	measurement string,
 ) error {
-	_, err := s.DB.ExecContext(ctx,
-		`INSERT INTO sensor_measurement (sensor_id, name)
-         VALUES (?, ?)
-         ON CONFLICT(sensor_id, name) DO NOTHING`,
-		sensorID, measurement)
-
-	if err != nil {
+	if err := updateSensorMeasurement(ctx, s.DB, sensorID, measurement); err != nil {
 		s.errorLog.Printf("Failed to add measurement %s for sensor %s: %v",
 			measurement, sensorID, err)
 		return err
@@ -89,9 +86,18 @@ func (s *SQLStorage) UpdateSensorMeasurement(
 	return nil
 }
 
+func updateSensorMeasurement(ctx context.Context, db dbtx, sensorID, measurement string) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO sensor_measurement (sensor_id, name)
+         VALUES (?, ?)
+         ON CONFLICT(sensor_id, name) DO NOTHING`,
+		sensorID, measurement)
+	return err
+}
+
 func (s *SQLStorage) GetAllSensorsWithMeasurements(ctx context.Context) ([]SensorWithMeasurements, error) {
 	const query = `
-        SELECT s.sensor_id, s.sensor_name, s.last_seen_unix, sm.name
+        SELECT s.sensor_id, s.sensor_name, s.last_seen_ms, sm.name
         FROM sensor s
         LEFT JOIN sensor_measurement sm ON sm.sensor_id = s.sensor_id
         ORDER BY s.sensor_id
@@ -128,7 +134,7 @@ func (s *SQLStorage) GetAllSensorsWithMeasurements(ctx context.Context) ([]Senso
 				item: SensorWithMeasurements{
 					SensorID:   sensorID,
 					SensorName: sensorName,
-					LastSeen:   time.Unix(timestamp, 0).UTC(),
+					LastSeen:   time.UnixMilli(timestamp).UTC(),
 				},
 			}
 			sensors[sensorID] = entry
@@ -3,7 +3,6 @@ package storage
 import (
 	"context"
 	"database/sql"
-	"errors"
 	"log"
 	"sensor/cmd/api/settings"
 
@@ -25,9 +24,6 @@ func (s *SQLStorage) InitDB(ctx context.Context) error {
 	if err := s.createTables(); err != nil {
 		return err
 	}
-	if err := s.createIndexByIDAndCreatedAtUnix(); err != nil {
-		return err
-	}
 	if err := s.EnsureDefaultSettings(ctx, settings.DefaultSettings); err != nil {
 		return err
 	}
@@ -47,6 +43,27 @@ func (s *SQLStorage) createTables() error {
 	if err := s.createSensorMeasurementTable(); err != nil {
 		return err
 	}
+	if err := s.createIdempotencyKeyTable(); err != nil {
+		return err
+	}
+	if err := s.createValidationRuleTable(); err != nil {
+		return err
+	}
+	if err := s.createQuarantineTable(); err != nil {
+		return err
+	}
+	if err := s.createUnitTable(); err != nil {
+		return err
+	}
+	if err := s.createCalibrationProfileTable(); err != nil {
+		return err
+	}
+	if err := s.createCollectorTargetTable(); err != nil {
+		return err
+	}
+	if err := s.createDerivedFormulaTable(); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -60,8 +77,17 @@ func (s *SQLStorage) createMeasurementTable() error {
         parameter TEXT,
         value REAL NOT NULL,
         unit TEXT,
-        timestamp_unix INTEGER NOT NULL,
-        created_at_unix INTEGER NOT NULL
+        timestamp_ms INTEGER NOT NULL,
+        created_at_ms INTEGER NOT NULL,
+        value_min REAL,
+        value_max REAL,
+        sample_count INTEGER NOT NULL DEFAULT 1,
+        message_id TEXT,
+        flag TEXT,
+        raw_value REAL,
+        raw_unit TEXT,
+        calibration_id INTEGER,
+        derived TEXT
     );
     `
 	_, err := s.DB.Exec(sqlCreate)
@@ -92,7 +118,7 @@ func (s *SQLStorage) createSensorTable() error {
     CREATE TABLE IF NOT EXISTS sensor (
         sensor_id TEXT PRIMARY KEY,
         sensor_name TEXT NOT NULL,
-        last_seen_unix INTEGER NOT NULL
+        last_seen_ms INTEGER NOT NULL
     )
     `
 	_, err := s.DB.Exec(sqlCreate)
@@ -118,29 +144,6 @@ func (s *SQLStorage) createSensorMeasurementTable() error {
 	return nil
 }
 
-func (s *SQLStorage) createIndexByIDAndCreatedAtUnix() error {
-	var count int
-	err := s.DB.QueryRow(`
-		SELECT COUNT(*) 
-		FROM pragma_table_info('measurement') 
-		WHERE name = 'created_at_unix'
-	`).Scan(&count)
-	if err != nil {
-		return err
-	}
-
-	if count == 0 {
-		s.errorLog.Println("Can't create index because colum 'created_at_unix' doesn't exist")
-		return errors.New("can't create index because column 'created_at_unix' doesn't exist")
-	}
-
-	_, err = s.DB.Exec(`
-        CREATE INDEX IF NOT EXISTS idx_measurement_created_at_unix_id
-        ON measurement(created_at_unix DESC, id DESC);
-	`)
-	return err
-}
-
 func (s *SQLStorage) EnsureDefaultSettings(ctx context.Context, defaults map[string]string) error {
 	for key, value := range defaults {
 		query := `
@@ -8,15 +8,17 @@ import (
 	"time"
 )
 
+// StorageCleaner deletes expired rows. Its deletes go through the writer
+// in small jobs, so they never compete with ingestion for the database.
 type StorageCleaner struct {
-	storage  *SQLStorage
+	writer   *Writer
 	infoLog  *log.Logger
 	errLog   *log.Logger
 	settings *settings.SettingsCache
 }
 
-func NewStorageCleaner(storage *SQLStorage, infoLog *log.Logger, errLog *log.Logger, settings *settings.SettingsCache) *StorageCleaner {
-	return &StorageCleaner{storage: storage, infoLog: infoLog, errLog: errLog, settings: settings}
+func NewStorageCleaner(writer *Writer, infoLog *log.Logger, errLog *log.Logger, settings *settings.SettingsCache) *StorageCleaner {
+	return &StorageCleaner{writer: writer, infoLog: infoLog, errLog: errLog, settings: settings}
 }
 
 func (c *StorageCleaner) StartCleanupJob(ctx context.Context, interval time.Duration) {
@@ -36,6 +38,8 @@ func (c *StorageCleaner) StartCleanupJob(ctx context.Context, interval time.Dura
 					break
 				}
 				c.infoLog.Println("Cleanup has finished")
+				c.cleanupIdempotencyKeys(ctx)
+				c.cleanupQuarantine(ctx)
 			}
 		}
 	}()
@@ -46,25 +50,46 @@ func (c *StorageCleaner) performCleanup(ctx context.Context) error {
 	cutOffTime := time.Now().UTC().Add(-maxAge)
 
 	for {
-		res, err := c.storage.DB.ExecContext(ctx, `
-        DELETE FROM measurement 
-        WHERE id IN (
-            SELECT id FROM measurement 
-            WHERE "timestamp_unix" < ? 
-            ORDER BY "timestamp_unix"
-            LIMIT 500
-        )
-        `, cutOffTime.Unix())
+		var n int64
+		err := c.writer.Do(ctx, func(ctx context.Context, tx *Tx) error {
+			var err error
+			n, err = tx.DeleteMeasurementsBefore(ctx, cutOffTime, 500)
+			return err
+		})
 		if err != nil {
 			return fmt.Errorf("cleanup delete measurements: %w", err)
 		}
-		n, err := res.RowsAffected()
-		if err != nil {
-			return fmt.Errorf("cleanup rows affected: %w", err)
-		}
-		c.infoLog.Printf("Cleanup %d records with timestamp_unix before %s max_age %s", n, cutOffTime.Format(time.RFC3339), maxAge.Round(time.Second))
+		c.infoLog.Printf("Cleanup %d records with timestamp before %s max_age %s", n, cutOffTime.Format(time.RFC3339), maxAge.Round(time.Second))
 		if n == 0 {
 			return nil
 		}
 	}
 }
+
+func (c *StorageCleaner) cleanupIdempotencyKeys(ctx context.Context) {
+	var n int64
+	err := c.writer.Do(ctx, func(ctx context.Context, tx *Tx) error {
+		var err error
+		n, err = tx.DeleteIdempotencyKeys(ctx, time.Now().UTC().Add(-IdempotencyKeyTTL))
+		return err
+	})
+	if err != nil {
+		c.errLog.Printf("Cleanup idempotency keys has failed with error %v", err)
+		return
+	}
+	c.infoLog.Printf("Cleanup %d idempotency keys", n)
+}
+
+func (c *StorageCleaner) cleanupQuarantine(ctx context.Context) {
+	var n int64
+	err := c.writer.Do(ctx, func(ctx context.Context, tx *Tx) error {
+		var err error
+		n, err = tx.PurgeQuarantine(ctx, 0, "", time.Now().UTC().Add(-c.settings.GetMaxAge()))
+		return err
+	})
+	if err != nil {
+		c.errLog.Printf("Cleanup quarantine has failed with error %v", err)
+		return
+	}
+	c.infoLog.Printf("Cleanup %d quarantined readings", n)
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sensor/cmd/api/models"
+	"time"
+)
+
+// dbtx is implemented by both *sql.DB and *sql.Tx, so the same statements
+// can run standalone or as part of a transaction.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Tx groups writes into a single transaction. It must be finished with
+// Commit or Rollback.
+type Tx struct {
+	tx *sql.Tx
+}
+
+func (s *SQLStorage) BeginTx(ctx context.Context) (*Tx, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{tx: tx}, nil
+}
+
+// WithTx runs fn in a transaction that is committed when fn succeeds and
+// rolled back otherwise.
+func (s *SQLStorage) WithTx(ctx context.Context, fn func(tx *Tx) error) error {
+	tx, err := s.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Savepoint runs fn inside a savepoint of the transaction. When fn fails
+// only its own writes are rolled back and the transaction stays usable.
+func (t *Tx) Savepoint(ctx context.Context, fn func() error) error {
+	if _, err := t.tx.ExecContext(ctx, `SAVEPOINT sp`); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		if _, rbErr := t.tx.ExecContext(ctx, `ROLLBACK TO sp`); rbErr != nil {
+			return errors.Join(err, rbErr)
+		}
+		_, _ = t.tx.ExecContext(ctx, `RELEASE sp`)
+		return err
+	}
+	_, err := t.tx.ExecContext(ctx, `RELEASE sp`)
+	return err
+}
+
+func (t *Tx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *Tx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+func (t *Tx) UpsertSensor(ctx context.Context, sensorID, sensorName *string, timestamp time.Time) error {
+	return upsertSensor(ctx, t.tx, sensorID, sensorName, timestamp)
+}
+
+func (t *Tx) UpdateSensorMeasurement(ctx context.Context, sensorID, measurement string) error {
+	return updateSensorMeasurement(ctx, t.tx, sensorID, measurement)
+}
+
+func (t *Tx) CreateMeasurement(ctx context.Context, sensorID, sensorName *string, m *models.MeasurementValue, timestamp time.Time, rule DedupRule) (MeasurementRecord, error) {
+	return createMeasurement(ctx, t.tx, sensorID, sensorName, m, timestamp, rule)
+}
+
+func (t *Tx) FindDuplicate(ctx context.Context, sensorID string, m *models.MeasurementValue, timestamp time.Time, rule DedupRule) (MeasurementRecord, bool, error) {
+	return findDuplicate(ctx, t.tx, sensorID, m, timestamp, rule)
+}
+
+// ErrMeasurementNotFound is returned by AggregateMeasurement when the
+// record was deleted, e.g. by the max_age cleaner.
+var ErrMeasurementNotFound = errors.New("measurement not found")
+
+func (t *Tx) AggregateMeasurement(ctx context.Context, id int64, value float64) (MeasurementRecord, error) {
+	record, err := aggregateMeasurement(ctx, t.tx, id, value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return MeasurementRecord{}, ErrMeasurementNotFound
+	}
+	return record, err
+}
+
+// DeleteMeasurementsBefore deletes up to limit of the oldest measurements
+// taken before cutOff and returns how many it deleted.
+func (t *Tx) DeleteMeasurementsBefore(ctx context.Context, cutOff time.Time, limit int) (int64, error) {
+	res, err := t.tx.ExecContext(ctx, `
+        DELETE FROM measurement
+        WHERE id IN (
+            SELECT id FROM measurement
+            WHERE "timestamp_ms" < ?
+            ORDER BY "timestamp_ms"
+            LIMIT ?
+        )
+        `, cutOff.UnixMilli(), limit)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"sensor/cmd/api/units"
+	"time"
+)
+
+// createUnitTable creates the canonical unit table and fills it with
+// units.DefaultCanonical. Defaults are only stored on creation so that
+// entries removed through the API stay removed.
+func (s *SQLStorage) createUnitTable() error {
+	var count int
+	if err := s.DB.QueryRow(`
+		SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'measurement_unit'
+	`).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	sqlCreate := `
+    CREATE TABLE IF NOT EXISTS measurement_unit (
+        measurement TEXT PRIMARY KEY,
+        unit TEXT NOT NULL,
+        updated_at_unix INTEGER NOT NULL
+    )
+    `
+	if _, err := s.DB.Exec(sqlCreate); err != nil {
+		return err
+	}
+	for _, c := range units.DefaultCanonical {
+		if _, err := s.UpsertCanonicalUnit(context.Background(), c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStorage) GetCanonicalUnits(ctx context.Context) ([]units.Canonical, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT measurement, unit, updated_at_unix FROM measurement_unit ORDER BY measurement`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []units.Canonical{}
+	for rows.Next() {
+		var c units.Canonical
+		var updatedAtUnix int64
+		if err := rows.Scan(&c.Measurement, &c.Unit, &updatedAtUnix); err != nil {
+			return nil, err
+		}
+		c.UpdatedAt = time.Unix(updatedAtUnix, 0).UTC()
+		out = append(out, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *SQLStorage) UpsertCanonicalUnit(ctx context.Context, c units.Canonical) (units.Canonical, error) {
+	c.UpdatedAt = time.Now().UTC()
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO measurement_unit (measurement, unit, updated_at_unix)
+		VALUES (?, ?, ?)
+		ON CONFLICT(measurement) DO UPDATE SET
+			unit = excluded.unit,
+			updated_at_unix = excluded.updated_at_unix`,
+		c.Measurement, c.Unit, c.UpdatedAt.Unix())
+	if err != nil {
+		s.errorLog.Printf("Failed to upsert canonical unit %s %v", c.Measurement, err)
+		return units.Canonical{}, err
+	}
+	return c, nil
+}
+
+// DeleteCanonicalUnit removes the entry of measurement and reports whether
+// there was one.
+func (s *SQLStorage) DeleteCanonicalUnit(ctx context.Context, measurement string) (bool, error) {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM measurement_unit WHERE measurement = ?`, measurement)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
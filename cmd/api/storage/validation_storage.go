@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"sensor/cmd/api/validation"
+	"time"
+)
+
+// createValidationRuleTable creates the rule table and fills it with
+// validation.DefaultRules. Defaults are only stored on creation so that
+// rules removed through the API stay removed.
+func (s *SQLStorage) createValidationRuleTable() error {
+	var count int
+	if err := s.DB.QueryRow(`
+		SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'validation_rule'
+	`).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	sqlCreate := `
+    CREATE TABLE IF NOT EXISTS validation_rule (
+        measurement TEXT PRIMARY KEY,
+        min REAL,
+        max REAL,
+        units TEXT NOT NULL,
+        sentinels TEXT NOT NULL,
+        action TEXT NOT NULL,
+        updated_at_unix INTEGER NOT NULL
+    )
+    `
+	if _, err := s.DB.Exec(sqlCreate); err != nil {
+		return err
+	}
+	for _, rule := range validation.DefaultRules {
+		if _, err := s.UpsertValidationRule(context.Background(), rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStorage) GetValidationRules(ctx context.Context) ([]validation.Rule, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT measurement, min, max, units, sentinels, action, updated_at_unix
+		FROM validation_rule
+		ORDER BY measurement`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []validation.Rule{}
+	for rows.Next() {
+		var rule validation.Rule
+		var units, sentinels string
+		var updatedAtUnix int64
+		if err := rows.Scan(&rule.Measurement, &rule.Min, &rule.Max, &units, &sentinels, &rule.Action, &updatedAtUnix); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(units), &rule.Units); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(sentinels), &rule.Sentinels); err != nil {
+			return nil, err
+		}
+		rule.UpdatedAt = time.Unix(updatedAtUnix, 0).UTC()
+		out = append(out, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *SQLStorage) UpsertValidationRule(ctx context.Context, rule validation.Rule) (validation.Rule, error) {
+	units, err := json.Marshal(rule.Units)
+	if err != nil {
+		return validation.Rule{}, err
+	}
+	sentinels, err := json.Marshal(rule.Sentinels)
+	if err != nil {
+		return validation.Rule{}, err
+	}
+	rule.UpdatedAt = time.Now().UTC()
+	_, err = s.DB.ExecContext(ctx, `
+		INSERT INTO validation_rule (measurement, min, max, units, sentinels, action, updated_at_unix)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(measurement) DO UPDATE SET
+			min = excluded.min,
+			max = excluded.max,
+			units = excluded.units,
+			sentinels = excluded.sentinels,
+			action = excluded.action,
+			updated_at_unix = excluded.updated_at_unix`,
+		rule.Measurement, rule.Min, rule.Max, string(units), string(sentinels), rule.Action, rule.UpdatedAt.Unix())
+	if err != nil {
+		s.errorLog.Printf("Failed to upsert validation rule %s %v", rule.Measurement, err)
+		return validation.Rule{}, err
+	}
+	return rule, nil
+}
+
+// DeleteValidationRule removes the rule of measurement and reports whether
+// there was one.
+func (s *SQLStorage) DeleteValidationRule(ctx context.Context, measurement string) (bool, error) {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM validation_rule WHERE measurement = ?`, measurement)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrWriterBusy is returned when a write cannot be queued in time because
+// the writer is saturated.
+var ErrWriterBusy = errors.New("write queue is full")
+
+// ErrWriterClosed is returned for writes submitted after Close.
+var ErrWriterClosed = errors.New("writer is closed")
+
+// WriteFunc performs the statements of one write job in tx.
+type WriteFunc func(ctx context.Context, tx *Tx) error
+
+type WriterConfig struct {
+	// QueueSize bounds the jobs waiting for the writer.
+	QueueSize int
+	// BatchSize bounds the jobs committed in one transaction.
+	BatchSize int
+	// Timeout bounds how long a job may wait to be queued and to start.
+	// Once started, Do waits for its commit however long that takes.
+	Timeout time.Duration
+}
+
+type writeJob struct {
+	ctx  context.Context
+	fn   WriteFunc
+	done chan error
+}
+
+// WriterStats describes the writer queue and recent commits. RejectedJobs
+// counts writes turned away by a full queue and TimedOutJobs queued writes
+// skipped because their timeout passed before they started; neither is
+// stored.
+type WriterStats struct {
+	QueueDepth    int     `json:"queue_depth"`
+	QueueCapacity int     `json:"queue_capacity"`
+	Jobs          uint64  `json:"jobs"`
+	FailedJobs    uint64  `json:"failed_jobs"`
+	RejectedJobs  uint64  `json:"rejected_jobs"`
+	TimedOutJobs  uint64  `json:"timed_out_jobs"`
+	Batches       uint64  `json:"batches"`
+	AvgBatchSize  float64 `json:"avg_batch_size"`
+	LastCommitMs  float64 `json:"last_commit_ms"`
+	AvgCommitMs   float64 `json:"avg_commit_ms"`
+	MaxCommitMs   float64 `json:"max_commit_ms"`
+}
+
+// Writer funnels ingestion writes through a single goroutine, so SQLite
+// never sees competing writers. Jobs waiting in the queue are committed
+// together in one transaction; each runs inside a savepoint, so a failing
+// job is rolled back without affecting the rest of its batch.
+type Writer struct {
+	infoLog  *log.Logger
+	errorLog *log.Logger
+	storage  *SQLStorage
+	config   WriterConfig
+	queue    chan *writeJob
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	stopped   chan struct{}
+
+	mu          sync.Mutex
+	stats       WriterStats
+	totalCommit time.Duration
+}
+
+func NewWriter(infoLog *log.Logger, errorLog *log.Logger, storage *SQLStorage, config WriterConfig) *Writer {
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1024
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 256
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Second
+	}
+	return &Writer{
+		infoLog:  infoLog,
+		errorLog: errorLog,
+		storage:  storage,
+		config:   config,
+		queue:    make(chan *writeJob, config.QueueSize),
+		closed:   make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+func (w *Writer) Start() {
+	go w.run()
+	w.infoLog.Printf("Writer started queue %d batch %d timeout %s", w.config.QueueSize, w.config.BatchSize, w.config.Timeout)
+}
+
+// Close stops accepting jobs, commits the queued ones and waits for the
+// writer goroutine to exit.
+func (w *Writer) Close() {
+	w.closeOnce.Do(func() { close(w.closed) })
+	<-w.stopped
+	w.infoLog.Println("Writer stopped")
+}
+
+// Do runs fn on the writer and waits for its transaction to commit. It
+// gives up with ErrWriterBusy when the queue stays full and returns the
+// context error when the job is still queued at the timeout; in both cases
+// nothing was written. A job that has started always runs to the end and
+// Do returns its outcome, so an error means the job is not stored.
+func (w *Writer) Do(ctx context.Context, fn WriteFunc) error {
+	ctx, cancel := context.WithTimeout(ctx, w.config.Timeout)
+	defer cancel()
+
+	job := &writeJob{ctx: ctx, fn: fn, done: make(chan error, 1)}
+	select {
+	case <-w.closed:
+		return ErrWriterClosed
+	default:
+	}
+	select {
+	case w.queue <- job:
+	case <-w.closed:
+		return ErrWriterClosed
+	case <-ctx.Done():
+		w.mu.Lock()
+		w.stats.RejectedJobs++
+		w.mu.Unlock()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return ErrWriterBusy
+		}
+		return ctx.Err()
+	}
+
+	// The writer answers every queued job, skipping those whose context
+	// ended before they started. Only a job queued while the writer was
+	// stopping may be left unanswered.
+	select {
+	case err := <-job.done:
+		return err
+	case <-w.stopped:
+		select {
+		case err := <-job.done:
+			return err
+		default:
+			return ErrWriterClosed
+		}
+	}
+}
+
+func (w *Writer) Stats() WriterStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stats := w.stats
+	stats.QueueDepth = len(w.queue)
+	stats.QueueCapacity = cap(w.queue)
+	if stats.Batches > 0 {
+		stats.AvgBatchSize = float64(stats.Jobs) / float64(stats.Batches)
+		stats.AvgCommitMs = float64(w.totalCommit.Microseconds()) / 1000 / float64(stats.Batches)
+	}
+	return stats
+}
+
+func (w *Writer) run() {
+	defer close(w.stopped)
+	batch := make([]*writeJob, 0, w.config.BatchSize)
+	for {
+		select {
+		case job := <-w.queue:
+			batch = append(batch[:0], job)
+		case <-w.closed:
+			// Drain what was queued before Close.
+			for {
+				select {
+				case job := <-w.queue:
+					w.commit([]*writeJob{job})
+				default:
+					return
+				}
+			}
+		}
+
+	fill:
+		for len(batch) < w.config.BatchSize {
+			select {
+			case job := <-w.queue:
+				batch = append(batch, job)
+			default:
+				break fill
+			}
+		}
+		w.commit(batch)
+	}
+}
+
+func (w *Writer) commit(batch []*writeJob) {
+	started := time.Now()
+	// The batch must not be interrupted by any single caller, because
+	// SQLite rolls back the whole transaction on an interrupt.
+	ctx := context.Background()
+	results := make([]error, len(batch))
+
+	tx, err := w.storage.BeginTx(ctx)
+	if err != nil {
+		w.finish(batch, fill(results, err), started)
+		return
+	}
+	for n, job := range batch {
+		if err := job.ctx.Err(); err != nil {
+			results[n] = fmt.Errorf("wait for writer: %w", err)
+			w.mu.Lock()
+			w.stats.TimedOutJobs++
+			w.mu.Unlock()
+			continue
+		}
+		results[n] = tx.Savepoint(ctx, func() error {
+			return job.fn(context.WithoutCancel(job.ctx), tx)
+		})
+	}
+	if err := tx.Commit(); err != nil {
+		w.errorLog.Printf("Writer commit of %d jobs failed %v", len(batch), err)
+		_ = tx.Rollback()
+		fill(results, err)
+	}
+	w.finish(batch, results, started)
+}
+
+func (w *Writer) finish(batch []*writeJob, results []error, started time.Time) {
+	latency := time.Since(started)
+
+	w.mu.Lock()
+	w.stats.Batches++
+	w.stats.Jobs += uint64(len(batch))
+	for _, err := range results {
+		if err != nil {
+			w.stats.FailedJobs++
+		}
+	}
+	w.stats.LastCommitMs = float64(latency.Microseconds()) / 1000
+	if w.stats.LastCommitMs > w.stats.MaxCommitMs {
+		w.stats.MaxCommitMs = w.stats.LastCommitMs
+	}
+	w.totalCommit += latency
+	w.mu.Unlock()
+
+	for n, job := range batch {
+		job.done <- results[n]
+	}
+}
+
+func fill(results []error, err error) []error {
+	for n := range results {
+		if results[n] == nil {
+			results[n] = err
+		}
+	}
+	return results
+}
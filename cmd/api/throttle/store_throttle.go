@@ -0,0 +1,117 @@
+// Package throttle decides which readings are persisted under store_interval
+package throttle
+
+import (
+	"sensor/cmd/api/settings"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Key identifies an independent store cadence. Measurement is empty when the
+// throttle scope is per sensor.
+type Key struct {
+	SensorID    string
+	Measurement string
+}
+
+type State struct {
+	SensorID     string    `json:"sensor_id"`
+	Measurement  string    `json:"measurement,omitempty"`
+	LastStoredAt time.Time `json:"last_stored_at"`
+	NextAcceptAt time.Time `json:"next_accept_at"`
+}
+
+// StoreThrottle tracks the last store time of every key. A write first
+// reserves its store time, which holds off concurrent writes for the key,
+// and confirms it once committed or releases it when the write failed.
+type StoreThrottle struct {
+	mu        sync.Mutex
+	lastStore map[Key]time.Time
+	reserved  map[Key]time.Time
+	settings  *settings.SettingsCache
+}
+
+func NewStoreThrottle(settings *settings.SettingsCache) *StoreThrottle {
+	return &StoreThrottle{
+		lastStore: make(map[Key]time.Time),
+		reserved:  make(map[Key]time.Time),
+		settings:  settings,
+	}
+}
+
+func (t *StoreThrottle) Key(sensorID, measurement string) Key {
+	if t.settings.GetThrottleScope() == settings.ThrottleScopeMeasurement {
+		return Key{SensorID: sensorID, Measurement: measurement}
+	}
+	return Key{SensorID: sensorID}
+}
+
+// Reserve reports whether a reading for key may be stored at now. When it
+// may, now is reserved for key until Confirm or Release.
+func (t *StoreThrottle) Reserve(key Key, now time.Time) bool {
+	storeInterval := t.settings.GetStoreInterval()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if last, ok := t.reserved[key]; ok && now.Sub(last) < storeInterval {
+		return false
+	}
+	if last, ok := t.lastStore[key]; ok && now.Sub(last) < storeInterval {
+		return false
+	}
+	t.reserved[key] = now
+	return true
+}
+
+// Confirm remembers the store time reserved at now as the last store time
+// of key, once the reading is committed.
+func (t *StoreThrottle) Confirm(key Key, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if last, ok := t.lastStore[key]; !ok || now.After(last) {
+		t.lastStore[key] = now
+	}
+	if t.reserved[key].Equal(now) {
+		delete(t.reserved, key)
+	}
+}
+
+// Release gives up the store time reserved at now when the reading was not
+// committed, so the next reading for key may be stored.
+func (t *StoreThrottle) Release(key Key, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.reserved[key].Equal(now) {
+		delete(t.reserved, key)
+	}
+}
+
+// Snapshot returns the throttle state of every known key, optionally limited
+// to one sensor, ordered by sensor and measurement.
+func (t *StoreThrottle) Snapshot(sensorID string) []State {
+	storeInterval := t.settings.GetStoreInterval()
+
+	t.mu.Lock()
+	out := make([]State, 0, len(t.lastStore))
+	for key, last := range t.lastStore {
+		if len(sensorID) != 0 && key.SensorID != sensorID {
+			continue
+		}
+		out = append(out, State{
+			SensorID:     key.SensorID,
+			Measurement:  key.Measurement,
+			LastStoredAt: last,
+			NextAcceptAt: last.Add(storeInterval),
+		})
+	}
+	t.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].SensorID != out[j].SensorID {
+			return out[i].SensorID < out[j].SensorID
+		}
+		return out[i].Measurement < out[j].Measurement
+	})
+	return out
+}
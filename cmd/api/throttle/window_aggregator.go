@@ -0,0 +1,85 @@
+package throttle
+
+import (
+	"sensor/cmd/api/models"
+	"sync"
+	"time"
+)
+
+type seriesKey struct {
+	sensorID    string
+	measurement string
+	parameter   string
+}
+
+func newSeriesKey(sensorID string, v *models.MeasurementValue) seriesKey {
+	key := seriesKey{sensorID: sensorID, measurement: v.Measurement}
+	if v.Parameter != nil {
+		key.parameter = *v.Parameter
+	}
+	return key
+}
+
+// Aggregator remembers which stored record represents the current
+// store_interval window of every series, so readings that are not stored on
+// their own can be folded into it. Windows older than store_interval are
+// forgotten, so the map does not grow with series that went silent.
+type Aggregator struct {
+	mu        sync.Mutex
+	windows   map[seriesKey]window
+	lastSweep time.Time
+}
+
+type window struct {
+	recordID int64
+	openedAt time.Time
+}
+
+func NewAggregator() *Aggregator {
+	return &Aggregator{windows: make(map[seriesKey]window)}
+}
+
+// Open starts a new window for the series of v backed by recordID, and
+// forgets expired windows of all series at most once per maxAge.
+func (a *Aggregator) Open(sensorID string, v *models.MeasurementValue, recordID int64, now time.Time, maxAge time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.windows[newSeriesKey(sensorID, v)] = window{recordID: recordID, openedAt: now}
+	if now.Sub(a.lastSweep) < maxAge {
+		return
+	}
+	a.lastSweep = now
+	for key, w := range a.windows {
+		if now.Sub(w.openedAt) >= maxAge {
+			delete(a.windows, key)
+		}
+	}
+}
+
+// RecordID returns the record of the open window for the series of v,
+// unless the window was opened maxAge or longer before now.
+func (a *Aggregator) RecordID(sensorID string, v *models.MeasurementValue, now time.Time, maxAge time.Duration) (int64, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := newSeriesKey(sensorID, v)
+	w, ok := a.windows[key]
+	if !ok {
+		return 0, false
+	}
+	if now.Sub(w.openedAt) >= maxAge {
+		delete(a.windows, key)
+		return 0, false
+	}
+	return w.recordID, true
+}
+
+// Close forgets the window of the series of v if it is backed by recordID,
+// e.g. because the record was deleted.
+func (a *Aggregator) Close(sensorID string, v *models.MeasurementValue, recordID int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := newSeriesKey(sensorID, v)
+	if a.windows[key].recordID == recordID {
+		delete(a.windows, key)
+	}
+}
@@ -0,0 +1,128 @@
+package udp
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sensor/cmd/api/models"
+	"time"
+)
+
+// binaryMagic starts a binary datagram (version 1). The layout, all
+// integers big endian, is:
+//
+//	u8  magic 0xA1
+//	u8  sensor id length, followed by the sensor id
+//	u32 sequence number
+//	u32 unix timestamp in seconds, 0 for the time of arrival
+//	u8  number of readings, each one being
+//	    u8 measurement name length, followed by the name
+//	    f32 value
+const binaryMagic = 0xA1
+
+// Datagram is one decoded UDP packet.
+type Datagram struct {
+	SensorID string
+	Seq      *uint32
+	Request  models.CreateMeasurementReq
+}
+
+// jsonDatagram is the JSON form: a create request that also names its
+// sensor and optionally carries a sequence number.
+type jsonDatagram struct {
+	SensorID string  `json:"sensor_id"`
+	Seq      *uint32 `json:"seq,omitempty"`
+	models.CreateMeasurementReq
+}
+
+func Decode(b []byte) (Datagram, error) {
+	if len(b) == 0 {
+		return Datagram{}, fmt.Errorf("%w: empty datagram", models.ErrBadPayload)
+	}
+	var d Datagram
+	var err error
+	if b[0] == binaryMagic {
+		d, err = decodeBinary(b)
+	} else {
+		d, err = decodeJSON(b)
+	}
+	if err != nil {
+		return Datagram{}, err
+	}
+	if len(d.SensorID) == 0 {
+		return Datagram{}, fmt.Errorf("%w: sensor_id is required", models.ErrBadPayload)
+	}
+	return d, nil
+}
+
+func decodeJSON(b []byte) (Datagram, error) {
+	var j jsonDatagram
+	if err := json.Unmarshal(b, &j); err != nil {
+		return Datagram{}, fmt.Errorf("%w: %v", models.ErrBadPayload, err)
+	}
+	return Datagram{SensorID: j.SensorID, Seq: j.Seq, Request: j.CreateMeasurementReq}, nil
+}
+
+func decodeBinary(b []byte) (Datagram, error) {
+	r := reader{b: b[1:]}
+	var d Datagram
+
+	d.SensorID = string(r.bytes(int(r.u8())))
+	d.Request.SensorName = d.SensorID
+	seq := r.u32()
+	d.Seq = &seq
+	if ts := r.u32(); ts != 0 {
+		d.Request.Timestamp = time.Unix(int64(ts), 0).UTC()
+	}
+	count := int(r.u8())
+	for i := 0; i < count; i++ {
+		name := string(r.bytes(int(r.u8())))
+		value := math.Float32frombits(r.u32())
+		d.Request.Measurements = append(d.Request.Measurements, models.MeasurementValue{
+			Measurement: name,
+			Value:       float64(value),
+		})
+	}
+	if r.short {
+		return Datagram{}, fmt.Errorf("%w: truncated binary datagram", models.ErrBadPayload)
+	}
+	if len(r.b) != 0 {
+		return Datagram{}, fmt.Errorf("%w: %d trailing bytes in binary datagram", models.ErrBadPayload, len(r.b))
+	}
+	return d, nil
+}
+
+// reader consumes a byte slice and remembers whether it ran out of data, so
+// callers check for truncation once at the end.
+type reader struct {
+	b     []byte
+	short bool
+}
+
+func (r *reader) bytes(n int) []byte {
+	if len(r.b) < n {
+		r.short = true
+		r.b = nil
+		return nil
+	}
+	out := r.b[:n]
+	r.b = r.b[n:]
+	return out
+}
+
+func (r *reader) u8() uint8 {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *reader) u32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
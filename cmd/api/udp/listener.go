@@ -0,0 +1,115 @@
+// Package udp ingests readings sent as UDP datagrams
+package udp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sensor/cmd/api/ingest"
+	"sync"
+	"time"
+)
+
+const (
+	maxDatagramSize = 2048
+	maxInFlight     = 4
+)
+
+// Listener receives datagrams and feeds them through the same ingestion
+// path as HTTP requests.
+type Listener struct {
+	port     int
+	conn     net.PacketConn
+	ingestor *ingest.Ingestor
+	tracker  *SequenceTracker
+	infoLog  *log.Logger
+	errorLog *log.Logger
+	wg       sync.WaitGroup
+}
+
+func NewListener(port int, ingestor *ingest.Ingestor, tracker *SequenceTracker, infoLog *log.Logger, errorLog *log.Logger) *Listener {
+	return &Listener{
+		port:     port,
+		ingestor: ingestor,
+		tracker:  tracker,
+		infoLog:  infoLog,
+		errorLog: errorLog,
+	}
+}
+
+func (l *Listener) Start() error {
+	conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", l.port))
+	if err != nil {
+		return err
+	}
+	l.conn = conn
+	l.infoLog.Printf("UDP listener on %s", conn.LocalAddr())
+
+	l.wg.Add(1)
+	go l.serve()
+	return nil
+}
+
+func (l *Listener) Close() {
+	if l.conn == nil {
+		return
+	}
+	if err := l.conn.Close(); err != nil {
+		l.errorLog.Printf("UDP listener close error %v", err)
+	}
+	l.wg.Wait()
+	l.infoLog.Println("UDP listener stopped")
+}
+
+func (l *Listener) serve() {
+	defer l.wg.Done()
+
+	// Storage may be slower than the network, so a few datagrams are
+	// processed concurrently while the socket keeps being drained.
+	sem := make(chan struct{}, maxInFlight)
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			l.errorLog.Printf("UDP read error %v", err)
+			continue
+		}
+		payload := make([]byte, n)
+		copy(payload, buf[:n])
+
+		sem <- struct{}{}
+		l.wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				l.wg.Done()
+			}()
+			if err := l.handle(payload); err != nil {
+				l.errorLog.Printf("UDP datagram from %s rejected %v", addr, err)
+			}
+		}()
+	}
+}
+
+func (l *Listener) handle(payload []byte) error {
+	d, err := Decode(payload)
+	if err != nil {
+		return err
+	}
+	if !l.tracker.Observe(d.SensorID, d.Seq, time.Now().UTC()) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := l.ingestor.Ingest(ctx, d.SensorID, &d.Request); err != nil {
+		l.tracker.Forget(d.SensorID, d.Seq)
+		return err
+	}
+	return nil
+}
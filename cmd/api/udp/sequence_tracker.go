@@ -0,0 +1,153 @@
+package udp
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// restartGap is how far a sequence number may jump backwards before it is
+// treated as a device restart rather than a late or duplicated datagram.
+const restartGap = 1024
+
+// seqWindow is how many sequence numbers up to the last one are remembered,
+// so a late datagram within it is told apart from a repeat.
+const seqWindow = 64
+
+// restartLow bounds the sequence numbers a restarted counter starts
+// with, allowing for its first datagrams to be lost.
+const restartLow = 4
+
+// restartIdle is how long a sensor may be silent before a sequence number
+// at or behind its last one is taken for a restarted counter.
+const restartIdle = time.Minute
+
+type SensorStats struct {
+	SensorID   string    `json:"sensor_id"`
+	Received   uint64    `json:"received"`
+	Lost       uint64    `json:"lost"`
+	Duplicates uint64    `json:"duplicates"`
+	Late       uint64    `json:"late"`
+	Restarts   uint64    `json:"restarts"`
+	LastSeq    *uint32   `json:"last_seq,omitempty"`
+	LastSeen   time.Time `json:"last_seen"`
+
+	// seen has bit n set when LastSeq-n was received.
+	seen uint64
+}
+
+// SequenceTracker counts received, lost and duplicated datagrams per sensor
+// from their sequence numbers.
+type SequenceTracker struct {
+	mu    sync.Mutex
+	stats map[string]*SensorStats
+}
+
+func NewSequenceTracker() *SequenceTracker {
+	return &SequenceTracker{stats: make(map[string]*SensorStats)}
+}
+
+// Observe records a datagram and reports whether it should be ingested.
+// A late datagram within seqWindow of the last sequence number is ingested
+// and no longer counted as lost, unless it was received before, which makes
+// it a duplicate. Older ones, up to restartGap behind, are dropped as late.
+// A number at or behind the last one after more than restartIdle of silence,
+// or one that fell back below restartLow without being new, is a device
+// that restarted its counter, as deep sleep nodes do on every wake.
+func (t *SequenceTracker) Observe(sensorID string, seq *uint32, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.stats[sensorID]
+	if !ok {
+		s = &SensorStats{SensorID: sensorID}
+		t.stats[sensorID] = s
+	}
+	idle := !s.LastSeen.IsZero() && now.Sub(s.LastSeen) > restartIdle
+	s.LastSeen = now
+
+	if seq == nil {
+		s.Received++
+		return true
+	}
+	if s.LastSeq == nil {
+		s.Received++
+		s.LastSeq = seq
+		s.seen = 1
+		return true
+	}
+
+	// Unsigned arithmetic keeps this right across uint32 wrap-around.
+	delta := *seq - *s.LastSeq
+	if delta != 0 && delta < 1<<31 {
+		s.Lost += uint64(delta - 1)
+		if delta < seqWindow {
+			s.seen = s.seen<<delta | 1
+		} else {
+			s.seen = 1
+		}
+		s.Received++
+		s.LastSeq = seq
+		return true
+	}
+
+	back := -delta
+	inWindow := back < seqWindow
+	seen := inWindow && s.seen&(1<<back) != 0
+	switch {
+	case idle, back > 0 && *seq < restartLow && (seen || !inWindow), back > restartGap:
+		s.Restarts++
+		s.seen = 1
+		s.LastSeq = seq
+	case seen:
+		s.Duplicates++
+		return false
+	case inWindow:
+		s.seen |= 1 << back
+		if s.Lost > 0 {
+			s.Lost--
+		}
+	default:
+		s.Late++
+		return false
+	}
+	s.Received++
+	return true
+}
+
+// Forget undoes Observe for a datagram that could not be ingested, so a
+// retry of it is not taken for a duplicate. It counts as lost until then.
+func (t *SequenceTracker) Forget(sensorID string, seq *uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.stats[sensorID]
+	if !ok || s.Received == 0 {
+		return
+	}
+	s.Received--
+	if seq == nil || s.LastSeq == nil {
+		return
+	}
+	s.Lost++
+	if back := *s.LastSeq - *seq; back < seqWindow {
+		s.seen &^= 1 << back
+	}
+}
+
+func (t *SequenceTracker) Snapshot() []SensorStats {
+	t.mu.Lock()
+	out := make([]SensorStats, 0, len(t.stats))
+	for _, s := range t.stats {
+		item := *s
+		if s.LastSeq != nil {
+			seq := *s.LastSeq
+			item.LastSeq = &seq
+		}
+		out = append(out, item)
+	}
+	t.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].SensorID < out[j].SensorID })
+	return out
+}
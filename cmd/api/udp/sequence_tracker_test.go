@@ -0,0 +1,96 @@
+package udp
+
+import (
+	"testing"
+	"time"
+)
+
+type observation struct {
+	seq    uint32
+	after  time.Duration
+	ingest bool
+}
+
+func observe(t *testing.T, tracker *SequenceTracker, steps []observation) SensorStats {
+	t.Helper()
+	now := time.Now()
+	for _, step := range steps {
+		now = now.Add(step.after)
+		seq := step.seq
+		if got := tracker.Observe("s", &seq, now); got != step.ingest {
+			t.Errorf("Observe(%d) = %v, want %v", seq, got, step.ingest)
+		}
+	}
+	return tracker.Snapshot()[0]
+}
+
+func TestSequenceTrackerLateDatagrams(t *testing.T) {
+	s := observe(t, NewSequenceTracker(), []observation{
+		{seq: 10, ingest: true},
+		{seq: 13, ingest: true},  // 11 and 12 lost
+		{seq: 11, ingest: true},  // late but new
+		{seq: 11, ingest: false}, // repeat
+		{seq: 13, ingest: false}, // repeat of the last one
+		{seq: 12, ingest: true},  // late but new
+		{seq: 200, ingest: true}, // 14-199 lost
+		{seq: 100, ingest: false},
+	})
+	if s.Received != 5 || s.Lost != 186 || s.Duplicates != 2 || s.Late != 1 || s.Restarts != 0 {
+		t.Errorf("stats = %+v", s)
+	}
+	if s.LastSeq == nil || *s.LastSeq != 200 {
+		t.Errorf("last seq = %v, want 200", s.LastSeq)
+	}
+}
+
+func TestSequenceTrackerRestart(t *testing.T) {
+	s := observe(t, NewSequenceTracker(), []observation{
+		{seq: 0, ingest: true},
+		{seq: 1, ingest: true},
+		{seq: 2, ingest: true},
+		// Rebooted below seqWindow: 0 and 1 were seen before.
+		{seq: 0, ingest: true},
+		{seq: 1, ingest: true},
+		{seq: 1, ingest: false},
+		{seq: 500, ingest: true},
+		// Rebooted further back than seqWindow.
+		{seq: 0, ingest: true},
+		{seq: 40, ingest: true},
+		// Woke from deep sleep with a counter that is not near zero.
+		{seq: 30, after: 5 * time.Minute, ingest: true},
+		{seq: 31, ingest: true},
+	})
+	if s.Restarts != 3 || s.Duplicates != 1 || s.Late != 0 {
+		t.Errorf("stats = %+v", s)
+	}
+	if s.LastSeq == nil || *s.LastSeq != 31 {
+		t.Errorf("last seq = %v, want 31", s.LastSeq)
+	}
+}
+
+func TestSequenceTrackerForget(t *testing.T) {
+	tracker := NewSequenceTracker()
+	observe(t, tracker, []observation{{seq: 7, ingest: true}, {seq: 8, ingest: true}})
+
+	seq := uint32(8)
+	tracker.Forget("s", &seq)
+	s := observe(t, tracker, []observation{
+		{seq: 8, ingest: true}, // retry after a failed ingest
+		{seq: 8, ingest: false},
+	})
+	if s.Received != 2 || s.Lost != 0 || s.Duplicates != 1 {
+		t.Errorf("stats = %+v", s)
+	}
+}
+
+func TestSequenceTrackerWrapAround(t *testing.T) {
+	s := observe(t, NewSequenceTracker(), []observation{
+		{seq: 1<<32 - 2, ingest: true},
+		{seq: 1, ingest: true},
+		{seq: 0, ingest: true},
+		{seq: 1<<32 - 1, ingest: true},
+	})
+	if s.Lost != 0 || s.Duplicates != 0 || s.Restarts != 0 {
+		t.Errorf("stats = %+v", s)
+	}
+}
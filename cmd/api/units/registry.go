@@ -0,0 +1,138 @@
+package units
+
+import (
+	"fmt"
+	"sensor/cmd/api/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Canonical is the unit that readings of Measurement are stored in.
+type Canonical struct {
+	Measurement string    `json:"measurement"`
+	Unit        string    `json:"unit"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// DefaultCanonical is stored when the unit table is created. The units
+// match what Sensor.Community firmware sends.
+var DefaultCanonical = []Canonical{
+	{Measurement: "pm1", Unit: "µg/m3"},
+	{Measurement: "pm25", Unit: "µg/m3"},
+	{Measurement: "pm4", Unit: "µg/m3"},
+	{Measurement: "pm10", Unit: "µg/m3"},
+	{Measurement: "temperature", Unit: "°C"},
+	{Measurement: "humidity", Unit: "%"},
+	{Measurement: "pressure", Unit: "Pa"},
+	{Measurement: "co2", Unit: "ppm"},
+	{Measurement: "no2", Unit: "µg/m3"},
+	{Measurement: "o3", Unit: "µg/m3"},
+	{Measurement: "so2", Unit: "µg/m3"},
+	{Measurement: "co", Unit: "mg/m3"},
+	{Measurement: "noise_laeq", Unit: "dB(A)"},
+	{Measurement: "noise_la_min", Unit: "dB(A)"},
+	{Measurement: "noise_la_max", Unit: "dB(A)"},
+	{Measurement: "wifi_signal", Unit: "dBm"},
+}
+
+// Validate checks an entry before it is persisted and replaces its unit
+// with the canonical spelling.
+func (c *Canonical) Validate() error {
+	if len(c.Measurement) == 0 {
+		return fmt.Errorf("measurement is required")
+	}
+	unit, ok := Lookup(c.Unit)
+	if !ok {
+		return fmt.Errorf("unknown unit %q, expected one of %v", c.Unit, Known())
+	}
+	c.Unit = unit
+	return nil
+}
+
+// Registry holds the canonical unit of each measurement name. Readings of
+// measurements without an entry are stored as received.
+type Registry struct {
+	mu        sync.RWMutex
+	canonical map[string]Canonical
+}
+
+func NewRegistry() *Registry {
+	return &Registry{canonical: make(map[string]Canonical)}
+}
+
+func (r *Registry) Load(items []Canonical) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.canonical = make(map[string]Canonical, len(items))
+	for _, c := range items {
+		r.canonical[c.Measurement] = c
+	}
+}
+
+func (r *Registry) Set(c Canonical) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.canonical[c.Measurement] = c
+}
+
+func (r *Registry) Delete(measurement string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.canonical, measurement)
+}
+
+func (r *Registry) Get(measurement string) (Canonical, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.canonical[measurement]
+	return c, ok
+}
+
+func (r *Registry) List() []Canonical {
+	r.mu.RLock()
+	out := make([]Canonical, 0, len(r.canonical))
+	for _, c := range r.canonical {
+		out = append(out, c)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Measurement < out[j].Measurement })
+	return out
+}
+
+// Normalize converts v in place to the canonical unit of its measurement.
+// A reading without a unit is taken to be in the canonical unit. When the
+// value or the unit's spelling changes, the reading as received is kept in
+// RawValue and RawUnit. Unknown and incompatible units wrap
+// models.ErrBadPayload.
+func (r *Registry) Normalize(v *models.MeasurementValue) error {
+	c, ok := r.Get(v.Measurement)
+	if !ok {
+		return nil
+	}
+	if v.Unit == nil {
+		unit := c.Unit
+		v.Unit = &unit
+		return nil
+	}
+
+	from, ok := Lookup(*v.Unit)
+	if !ok {
+		return fmt.Errorf("%w: %s unit %q is unknown, expected %s or a convertible unit", models.ErrBadPayload, v.Measurement, *v.Unit, c.Unit)
+	}
+	if *v.Unit == c.Unit {
+		return nil
+	}
+	value, err := Convert(v.Measurement, v.Value, from, c.Unit)
+	if err != nil {
+		return fmt.Errorf("%w: %v", models.ErrBadPayload, err)
+	}
+
+	rawValue, rawUnit, unit := v.Value, *v.Unit, c.Unit
+	v.RawValue = &rawValue
+	v.RawUnit = &rawUnit
+	v.Value = value
+	v.Unit = &unit
+	return nil
+}
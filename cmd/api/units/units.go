@@ -0,0 +1,144 @@
+// Package units converts readings to the canonical unit of their measurement
+package units
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Dimensions a unit can measure. Units convert freely within a dimension;
+// gas readings also convert between mixing ratio and mass concentration.
+const (
+	DimTemperature       = "temperature"
+	DimPressure          = "pressure"
+	DimMassConcentration = "mass_concentration"
+	DimMixingRatio       = "mixing_ratio"
+	DimRelative          = "relative"
+	DimSoundLevel        = "sound_level"
+	DimPower             = "power"
+)
+
+// unit converts values to and from the base unit of its dimension: °C,
+// Pa, µg/m3, ppb, %, dB(A) and dBm.
+type unit struct {
+	name      string
+	dimension string
+	toBase    func(float64) float64
+	fromBase  func(float64) float64
+}
+
+func scale(factor float64) (func(float64) float64, func(float64) float64) {
+	return func(v float64) float64 { return v * factor }, func(v float64) float64 { return v / factor }
+}
+
+func identity(v float64) float64 {
+	return v
+}
+
+func linear(name, dimension string, factor float64) unit {
+	to, from := scale(factor)
+	return unit{name: name, dimension: dimension, toBase: to, fromBase: from}
+}
+
+var knownUnits = []unit{
+	{name: "°C", dimension: DimTemperature, toBase: identity, fromBase: identity},
+	{name: "°F", dimension: DimTemperature,
+		toBase:   func(v float64) float64 { return (v - 32) * 5 / 9 },
+		fromBase: func(v float64) float64 { return v*9/5 + 32 }},
+	{name: "K", dimension: DimTemperature,
+		toBase:   func(v float64) float64 { return v - 273.15 },
+		fromBase: func(v float64) float64 { return v + 273.15 }},
+	linear("Pa", DimPressure, 1),
+	linear("hPa", DimPressure, 100),
+	linear("kPa", DimPressure, 1000),
+	linear("mmHg", DimPressure, 133.322387415),
+	linear("inHg", DimPressure, 3386.389),
+	linear("µg/m3", DimMassConcentration, 1),
+	linear("mg/m3", DimMassConcentration, 1000),
+	linear("ppb", DimMixingRatio, 1),
+	linear("ppm", DimMixingRatio, 1000),
+	linear("%", DimRelative, 1),
+	linear("dB(A)", DimSoundLevel, 1),
+	linear("dBm", DimPower, 1),
+}
+
+// aliases maps lower-cased spellings seen in the field to a known unit.
+var aliases = map[string]string{
+	"°c": "°C", "c": "°C", "degc": "°C", "celsius": "°C", "℃": "°C",
+	"°f": "°F", "f": "°F", "degf": "°F", "fahrenheit": "°F", "℉": "°F",
+	"k": "K", "kelvin": "K",
+	"pa": "Pa", "hpa": "hPa", "mbar": "hPa", "kpa": "kPa", "mmhg": "mmHg", "inhg": "inHg",
+	"µg/m3": "µg/m3", "µg/m³": "µg/m3", "μg/m3": "µg/m3", "μg/m³": "µg/m3", "ug/m3": "µg/m3", "ug/m³": "µg/m3",
+	"mg/m3": "mg/m3", "mg/m³": "mg/m3",
+	"ppb": "ppb", "ppm": "ppm",
+	"%": "%", "%rh": "%", "percent": "%",
+	"db(a)": "dB(A)", "dba": "dB(A)",
+	"dbm": "dBm",
+}
+
+// molarMass of gases in g/mol, used to convert between ppb and µg/m3 at
+// 25 °C and 1 atm, where one mole of gas takes 24.45 litres.
+var molarMass = map[string]float64{
+	"no2": 46.01,
+	"no":  30.01,
+	"o3":  48.00,
+	"so2": 64.07,
+	"co":  28.01,
+	"nh3": 17.03,
+	"h2s": 34.08,
+}
+
+const molarVolume = 24.45
+
+var unitsByName = func() map[string]unit {
+	m := make(map[string]unit, len(knownUnits))
+	for _, u := range knownUnits {
+		m[u.name] = u
+	}
+	return m
+}()
+
+// Lookup returns the canonical spelling of a unit, e.g. "µg/m3" for
+// "ug/m³", and false for units it does not know.
+func Lookup(name string) (string, bool) {
+	canonical, ok := aliases[strings.ToLower(strings.TrimSpace(name))]
+	return canonical, ok
+}
+
+// Known lists the canonical spelling of every supported unit.
+func Known() []string {
+	out := make([]string, 0, len(knownUnits))
+	for _, u := range knownUnits {
+		out = append(out, u.name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Convert converts value of measurement from one known unit to another.
+// Both units must be canonical spellings as returned by Lookup.
+func Convert(measurement string, value float64, from, to string) (float64, error) {
+	src, ok := unitsByName[from]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", from)
+	}
+	dst, ok := unitsByName[to]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", to)
+	}
+
+	base := src.toBase(value)
+	if src.dimension != dst.dimension {
+		mass, ok := molarMass[strings.ToLower(measurement)]
+		switch {
+		case ok && src.dimension == DimMixingRatio && dst.dimension == DimMassConcentration:
+			base = base * mass / molarVolume
+		case ok && src.dimension == DimMassConcentration && dst.dimension == DimMixingRatio:
+			base = base * molarVolume / mass
+		default:
+			return 0, fmt.Errorf("cannot convert %s from %s to %s", measurement, from, to)
+		}
+	}
+	return dst.fromBase(base), nil
+}
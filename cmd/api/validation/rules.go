@@ -0,0 +1,174 @@
+// Package validation checks readings against per-measurement rules
+package validation
+
+import (
+	"fmt"
+	"math"
+	"sensor/cmd/api/models"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Actions a rule takes when a reading breaks it.
+const (
+	// ActionReject moves the reading to quarantine instead of storing it.
+	ActionReject = "reject"
+	// ActionClamp stores the reading with its value limited to min/max.
+	ActionClamp = "clamp"
+	// ActionFlag stores the reading unchanged with the reason as its flag.
+	ActionFlag = "flag"
+	// ActionAccept is the verdict for a reading that passed all checks.
+	ActionAccept = ""
+)
+
+// Rule limits the readings of one measurement name. Readings equal to one
+// of Sentinels, and NaN or infinite values, are always rejected because
+// they only come from broken sensors.
+type Rule struct {
+	Measurement string    `json:"measurement"`
+	Min         *float64  `json:"min,omitempty"`
+	Max         *float64  `json:"max,omitempty"`
+	Units       []string  `json:"units,omitempty"`
+	Sentinels   []float64 `json:"sentinels,omitempty"`
+	Action      string    `json:"action"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func ptr(v float64) *float64 {
+	return &v
+}
+
+func pmRule(measurement string) Rule {
+	return Rule{Measurement: measurement, Min: ptr(0), Max: ptr(1000), Sentinels: []float64{65535}, Action: ActionReject}
+}
+
+// DefaultRules are stored when the rule table is created.
+var DefaultRules = []Rule{
+	pmRule("pm1"),
+	pmRule("pm25"),
+	pmRule("pm4"),
+	pmRule("pm10"),
+	{Measurement: "humidity", Min: ptr(0), Max: ptr(100), Action: ActionClamp},
+}
+
+// Validate checks a rule before it is persisted.
+func (r *Rule) Validate() error {
+	if len(r.Measurement) == 0 {
+		return fmt.Errorf("measurement is required")
+	}
+	switch r.Action {
+	case ActionReject, ActionClamp, ActionFlag:
+	default:
+		return fmt.Errorf("action must be '%s', '%s' or '%s'", ActionReject, ActionClamp, ActionFlag)
+	}
+	if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+		return fmt.Errorf("min must not be greater than max")
+	}
+	return nil
+}
+
+// Verdict is the outcome of checking one reading. Value is the value to
+// store, which differs from the reading only when it was clamped.
+type Verdict struct {
+	Action string
+	Reason string
+	Value  float64
+}
+
+// Rules holds the active rules keyed by measurement name. It is read on
+// every ingested reading and updated through the rules API.
+type Rules struct {
+	mu    sync.RWMutex
+	rules map[string]Rule
+}
+
+func NewRules() *Rules {
+	return &Rules{rules: make(map[string]Rule)}
+}
+
+func (r *Rules) Load(rules []Rule) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rules = make(map[string]Rule, len(rules))
+	for _, rule := range rules {
+		r.rules[rule.Measurement] = rule
+	}
+}
+
+func (r *Rules) Set(rule Rule) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rules[rule.Measurement] = rule
+}
+
+func (r *Rules) Delete(measurement string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.rules, measurement)
+}
+
+func (r *Rules) Get(measurement string) (Rule, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	rule, ok := r.rules[measurement]
+	return rule, ok
+}
+
+func (r *Rules) List() []Rule {
+	r.mu.RLock()
+	out := make([]Rule, 0, len(r.rules))
+	for _, rule := range r.rules {
+		out = append(out, rule)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Measurement < out[j].Measurement })
+	return out
+}
+
+// Check applies the rule of the reading's measurement. Units are compared
+// exactly; a reading without a unit breaks a rule that lists units.
+func (r *Rules) Check(v *models.MeasurementValue) Verdict {
+	if math.IsNaN(v.Value) || math.IsInf(v.Value, 0) {
+		return Verdict{Action: ActionReject, Reason: fmt.Sprintf("%s value is not a finite number", v.Measurement), Value: v.Value}
+	}
+
+	rule, ok := r.Get(v.Measurement)
+	if !ok {
+		return Verdict{Action: ActionAccept, Value: v.Value}
+	}
+
+	if slices.Contains(rule.Sentinels, v.Value) {
+		return Verdict{Action: ActionReject, Reason: fmt.Sprintf("%s value %g is a sensor error sentinel", v.Measurement, v.Value), Value: v.Value}
+	}
+
+	if len(rule.Units) > 0 && (v.Unit == nil || !slices.Contains(rule.Units, *v.Unit)) {
+		unit := ""
+		if v.Unit != nil {
+			unit = *v.Unit
+		}
+		action := rule.Action
+		if action == ActionClamp {
+			// A wrong unit cannot be fixed by clamping.
+			action = ActionReject
+		}
+		return Verdict{Action: action, Reason: fmt.Sprintf("%s unit %q is not one of %v", v.Measurement, unit, rule.Units), Value: v.Value}
+	}
+
+	switch {
+	case rule.Min != nil && v.Value < *rule.Min:
+		return rule.outOfRange(v, fmt.Sprintf("%s value %g is below min %g", v.Measurement, v.Value, *rule.Min), *rule.Min)
+	case rule.Max != nil && v.Value > *rule.Max:
+		return rule.outOfRange(v, fmt.Sprintf("%s value %g is above max %g", v.Measurement, v.Value, *rule.Max), *rule.Max)
+	}
+	return Verdict{Action: ActionAccept, Value: v.Value}
+}
+
+func (r *Rule) outOfRange(v *models.MeasurementValue, reason string, limit float64) Verdict {
+	if r.Action == ActionClamp {
+		return Verdict{Action: ActionClamp, Reason: reason + ", clamped", Value: limit}
+	}
+	return Verdict{Action: r.Action, Reason: reason, Value: v.Value}
+}